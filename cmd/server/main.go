@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/config"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/handler"
@@ -54,6 +56,21 @@ func main() {
 	ctx := context.Background()
 	messageRepo := repository.NewMessageRepository(db)
 
+	if err := messageRepo.EnsureIndexes(ctx); err != nil {
+		log.Error("Failed to create indexes: %v", err)
+	}
+
+	if backfilled, err := messageRepo.BackfillPriority(ctx); err != nil {
+		log.Error("Failed to backfill message priorities: %v", err)
+	} else if backfilled > 0 {
+		log.Info("Gave %d messages without a priority the normal priority", backfilled)
+	}
+
+	importRepo := repository.NewImportRepository(db)
+	if err := importRepo.EnsureIndexes(ctx); err != nil {
+		log.Error("Failed to create import indexes: %v", err)
+	}
+
 	// Seed sample data if database is empty
 	log.Info("Checking database for sample data...")
 	if err := messageRepo.(interface {
@@ -83,19 +100,86 @@ func main() {
 	defer redisClient.Close()
 	log.Info("Redis connected")
 
-	webhookClient := service.NewWebhookClient(
-		cfg.Webhook.URL,
-		cfg.Webhook.AuthKey,
-		cfg.Webhook.Timeout,
-		cfg.Webhook.MaxRetries,
-		cfg.Webhook.RetryDelay,
-	)
+	providers := make([]*service.Provider, 0, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		jitter, err := service.ParseJitterMode(pc.RetryJitter)
+		if err != nil {
+			log.Error("Invalid %sRETRY_JITTER: %v", pc.EnvPrefix, err)
+			os.Exit(1)
+		}
+
+		// Each provider has its own contract, so each gets its own bucket
+		var limiter service.RateLimiter
+		if cfg.RateLimit.PerSecond > 0 {
+			if cfg.RateLimit.Shared {
+				limiter = service.NewRedisTokenBucket(redisClient, cfg.RateLimit.Key+":"+pc.Name, cfg.RateLimit.PerSecond, cfg.RateLimit.Burst, nil, log)
+			} else {
+				limiter = service.NewTokenBucket(cfg.RateLimit.PerSecond, cfg.RateLimit.Burst, nil)
+			}
+		}
+
+		provider := &service.Provider{
+			Name: pc.Name,
+			Client: service.NewWebhookClient(
+				pc.URL,
+				pc.AuthKey,
+				pc.Timeout,
+				&service.RetryPolicy{
+					MaxRetries: pc.MaxRetries,
+					BaseDelay:  pc.RetryDelay,
+					MaxDelay:   pc.RetryMaxDelay,
+					Jitter:     jitter,
+					Budget:     pc.RetryBudget,
+				},
+				limiter,
+			),
+		}
+
+		if cfg.Breaker.Enabled {
+			provider.Breaker = service.NewCircuitBreaker(provider.Client, service.CircuitBreakerConfig{
+				ConsecutiveFailures: cfg.Breaker.ConsecutiveFailures,
+				FailureRate:         cfg.Breaker.FailureRate,
+				WindowSize:          cfg.Breaker.WindowSize,
+				MinRequests:         cfg.Breaker.MinRequests,
+				OpenTimeout:         cfg.Breaker.OpenTimeout,
+				HalfOpenRequests:    cfg.Breaker.HalfOpenRequests,
+			}, nil)
+			provider.Client = provider.Breaker
+		}
+
+		providers = append(providers, provider)
+	}
+
+	rules, err := service.ParseRoutingRules(cfg.RoutingRules)
+	if err != nil {
+		log.Error("Invalid ROUTING_RULES: %v", err)
+		os.Exit(1)
+	}
+
+	router, err := service.NewRouter(providers, rules, log)
+	if err != nil {
+		log.Error("Invalid provider configuration: %v", err)
+		os.Exit(1)
+	}
+	log.Info("Configured %d providers with %d routing rules", len(providers), len(rules))
 
 	messageService := service.NewMessageService(
 		messageRepo,
-		webhookClient,
+		router,
 		redisClient,
 		log,
+		service.Options{
+			InstanceID:        cfg.Scheduler.InstanceID,
+			ClaimLease:        cfg.Scheduler.ClaimLease,
+			StarvationAge:     cfg.Scheduler.StarvationAge,
+			MaxAttempts:       cfg.Delivery.MaxAttempts,
+			RetryBaseDelay:    cfg.Delivery.RetryBaseDelay,
+			RetryMaxDelay:     cfg.Delivery.RetryMaxDelay,
+			Concurrency:       cfg.Delivery.Concurrency,
+			InsertChunkSize:   cfg.Ingest.InsertChunkSize,
+			IdempotencyWindow: cfg.Ingest.IdempotencyWindow,
+			DedupWindow:       cfg.Dedup.Window,
+		},
 	)
 
 	schedule := scheduler.NewScheduler(
@@ -105,6 +189,33 @@ func main() {
 		log,
 	)
 
+	schedule.SetBatchTimeout(cfg.Scheduler.BatchTimeout)
+	schedule.SetClaimLease(cfg.Scheduler.ClaimLease)
+	schedule.SetSettingsStore(repository.NewSchedulerSettingsRepository(db))
+	if err := schedule.LoadSettings(ctx); err != nil {
+		log.Error("Failed to load scheduler settings: %v", err)
+	}
+
+	if cfg.Scheduler.Cron != "" {
+		location, _ := time.LoadLocation(cfg.Scheduler.Timezone)
+		cronSchedule, err := scheduler.ParseCron(cfg.Scheduler.Cron, location)
+		if err != nil {
+			log.Error("Invalid SCHEDULER_CRON: %v", err)
+			os.Exit(1)
+		}
+		schedule.SetSchedule(cronSchedule)
+	}
+
+	if cfg.Scheduler.LeaderElection {
+		log.Info("Leader election enabled for instance %s", cfg.Scheduler.InstanceID)
+		schedule.SetLeaderElector(scheduler.NewRedisLeaderElector(
+			redisClient,
+			cfg.Scheduler.LeaderKey,
+			cfg.Scheduler.InstanceID,
+			cfg.Scheduler.LeaderTTL,
+		))
+	}
+
 	if cfg.Scheduler.AutoStartEnabled {
 		log.Info("Auto-starting scheduler...")
 		if err := schedule.Start(); err != nil {
@@ -112,22 +223,41 @@ func main() {
 		}
 	}
 
-	schedulerHandler := handler.NewSchedulerHandler(schedule)
-	messageHandler := handler.NewMessageHandler(messageService)
+	schedulerHandler := handler.NewSchedulerHandler(schedule, router)
+	healthHandler := handler.NewHealthHandler(router)
+	messageHandler := handler.NewMessageHandler(messageService, cfg.Ingest.MaxBatchSize, log)
+	importHandler := handler.NewImportHandler(
+		service.NewImportService(messageService, importRepo, log, cfg.Ingest.InsertChunkSize),
+		log,
+	)
+	callbackHandler := handler.NewCallbackHandler(messageService, cfg.Callback.Secret)
+	if cfg.Callback.Secret == "" {
+		log.Info("CALLBACK_SECRET not set, delivery receipts are disabled")
+	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/scheduler/start", schedulerHandler.Start)
 	mux.HandleFunc("/api/scheduler/stop", schedulerHandler.Stop)
 	mux.HandleFunc("/api/scheduler/status", schedulerHandler.Status)
+	mux.HandleFunc("/api/scheduler/config", schedulerHandler.Config)
+	mux.HandleFunc("/api/scheduler/trigger", schedulerHandler.Trigger)
+	mux.HandleFunc("/api/scheduler/runs", schedulerHandler.Runs)
 
 	mux.HandleFunc("/api/messages/sent", messageHandler.GetSentMessages)
-	mux.HandleFunc("/api/messages", messageHandler.CreateMessage)
+	mux.HandleFunc("/api/messages/stats", messageHandler.GetMessageStats)
+	mux.HandleFunc("/api/messages/batch", messageHandler.CreateMessages)
+	mux.HandleFunc("/api/messages/export", messageHandler.ExportMessages)
+	mux.HandleFunc("/api/messages/import", importHandler.Import)
+	mux.HandleFunc("/api/messages/import/", importHandler.Import)
+	mux.HandleFunc("/api/messages/dead-letter", messageHandler.DeadLetter)
+	mux.HandleFunc("/api/messages/dead-letter/", messageHandler.DeadLetter)
+	mux.HandleFunc("/api/messages", messageHandler.Messages)
+	mux.HandleFunc("/api/messages/", messageHandler.Message)
 
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("/api/callbacks/delivery", callbackHandler.Delivery)
+
+	mux.HandleFunc("/health", healthHandler.Health)
 
 	mux.HandleFunc("/swagger", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./api/index.html")
@@ -164,8 +294,27 @@ func main() {
 	log.Info("  POST   /api/scheduler/start")
 	log.Info("  POST   /api/scheduler/stop")
 	log.Info("  GET    /api/scheduler/status")
+	log.Info("  GET    /api/scheduler/config")
+	log.Info("  PUT    /api/scheduler/config")
+	log.Info("  POST   /api/scheduler/trigger")
+	log.Info("  GET    /api/scheduler/runs")
 	log.Info("  GET    /api/messages/sent")
+	log.Info("  GET    /api/messages/stats")
+	log.Info("  GET    /api/messages/dead-letter")
+	log.Info("  GET    /api/messages/dead-letter/{id}")
+	log.Info("  POST   /api/messages/dead-letter/{id}/requeue")
+	log.Info("  GET    /api/messages")
 	log.Info("  POST   /api/messages")
+	log.Info("  POST   /api/messages/batch")
+	log.Info("  GET    /api/messages/export")
+	log.Info("  POST   /api/messages/import")
+	log.Info("  GET    /api/messages/import/{id}")
+	log.Info("  GET    /api/messages/import/{id}/errors")
+	log.Info("  GET    /api/messages/{id}")
+	log.Info("  POST   /api/messages/{id}/cancel")
+	log.Info("  POST   /api/messages/{id}/retry")
+	log.Info("  GET    /api/messages/{id}/events")
+	log.Info("  POST   /api/callbacks/delivery")
 	log.Info("  GET    /health")
 
 	quit := make(chan os.Signal, 1)
@@ -174,11 +323,10 @@ func main() {
 
 	log.Info("Shutting down server...")
 
-	if schedule.IsRunning() {
-		log.Info("Stopping scheduler...")
-		if err := schedule.Stop(); err != nil {
-			log.Error("Failed to stop scheduler: %v", err)
-		}
+	// Also cancels and waits for a manually triggered batch
+	log.Info("Stopping scheduler...")
+	if err := schedule.Stop(); err != nil {
+		log.Error("Failed to stop scheduler: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,15 @@ type Config struct {
 	Redis     RedisConfig
 	Scheduler SchedulerConfig
 	Webhook   WebhookConfig
+	Providers []ProviderConfig
+	// RoutingRules picks a provider per message, see service.ParseRoutingRules
+	RoutingRules string
+	Delivery     DeliveryConfig
+	Breaker      BreakerConfig
+	RateLimit    RateLimitConfig
+	Callback     CallbackConfig
+	Ingest       IngestConfig
+	Dedup        DedupConfig
 }
 
 type ServerConfig struct {
@@ -37,15 +47,89 @@ type RedisConfig struct {
 type SchedulerConfig struct {
 	Interval         time.Duration
 	BatchSize        int
+	BatchTimeout     time.Duration
 	AutoStartEnabled bool
+	InstanceID       string
+	ClaimLease       time.Duration
+	StarvationAge    time.Duration
+	LeaderElection   bool
+	LeaderKey        string
+	LeaderTTL        time.Duration
+	Cron             string
+	Timezone         string
 }
 
 type WebhookConfig struct {
-	URL        string
-	AuthKey    string
-	Timeout    time.Duration
-	MaxRetries int
-	RetryDelay time.Duration
+	URL           string
+	AuthKey       string
+	Timeout       time.Duration
+	MaxRetries    int
+	RetryDelay    time.Duration
+	RetryMaxDelay time.Duration
+	RetryJitter   string
+	RetryBudget   time.Duration
+}
+
+// ProviderConfig is one named SMS provider. Settings it does not override
+// fall back to the WEBHOOK_* values.
+type ProviderConfig struct {
+	Name string
+	// EnvPrefix is the prefix of this provider's variables, used in errors
+	EnvPrefix string
+	WebhookConfig
+}
+
+// DeliveryConfig controls how a batch is sent and how failed messages are
+// retried across scheduler ticks
+type DeliveryConfig struct {
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	Concurrency    int
+}
+
+// BreakerConfig configures the circuit breaker around the webhook provider
+type BreakerConfig struct {
+	Enabled             bool
+	ConsecutiveFailures int
+	FailureRate         float64
+	WindowSize          int
+	MinRequests         int
+	OpenTimeout         time.Duration
+	HalfOpenRequests    int
+}
+
+// RateLimitConfig throttles requests to the webhook provider
+type RateLimitConfig struct {
+	// PerSecond is the sustained send rate; zero disables rate limiting
+	PerSecond float64
+	Burst     int
+	// Shared keeps the bucket in Redis so the limit holds across replicas
+	Shared bool
+	Key    string
+}
+
+// CallbackConfig secures the inbound provider callbacks
+type CallbackConfig struct {
+	// Secret verifies delivery receipts; empty disables the callback endpoints
+	Secret string
+}
+
+// DedupConfig suppresses repeated messages to the same recipient
+type DedupConfig struct {
+	// Window is how long a message suppresses others with the same recipient
+	// and content; zero disables the check
+	Window time.Duration
+}
+
+// IngestConfig limits bulk message creation
+type IngestConfig struct {
+	// MaxBatchSize is the most messages one batch request may contain
+	MaxBatchSize int
+	// InsertChunkSize is the number of messages stored per InsertMany
+	InsertChunkSize int
+	// IdempotencyWindow is how long an idempotency key replays its message
+	IdempotencyWindow time.Duration
 }
 
 func Load() (*Config, error) {
@@ -69,33 +153,196 @@ func Load() (*Config, error) {
 		Scheduler: SchedulerConfig{
 			Interval:         getDurationEnv("SCHEDULER_INTERVAL", 2*time.Minute),
 			BatchSize:        getIntEnv("SCHEDULER_BATCH_SIZE", 2),
+			BatchTimeout:     getDurationEnv("SCHEDULER_BATCH_TIMEOUT", 2*time.Minute),
 			AutoStartEnabled: getBoolEnv("SCHEDULER_AUTO_START", true),
+			InstanceID:       getEnv("SCHEDULER_INSTANCE_ID", defaultInstanceID()),
+			ClaimLease:       getDurationEnv("SCHEDULER_CLAIM_LEASE", 5*time.Minute),
+			StarvationAge:    getDurationEnv("SCHEDULER_STARVATION_AGE", 10*time.Minute),
+			LeaderElection:   getBoolEnv("SCHEDULER_LEADER_ELECTION", false),
+			LeaderKey:        getEnv("SCHEDULER_LEADER_KEY", "scheduler:leader"),
+			LeaderTTL:        getDurationEnv("SCHEDULER_LEADER_TTL", 15*time.Second),
+			Cron:             getEnv("SCHEDULER_CRON", ""),
+			Timezone:         getEnv("SCHEDULER_TIMEZONE", "UTC"),
 		},
 		Webhook: WebhookConfig{
-			URL:        getEnv("WEBHOOK_URL", ""),
-			AuthKey:    getEnv("WEBHOOK_AUTH_KEY", ""),
-			Timeout:    getDurationEnv("WEBHOOK_TIMEOUT", 30*time.Second),
-			MaxRetries: getIntEnv("WEBHOOK_MAX_RETRIES", 3),
-			RetryDelay: getDurationEnv("WEBHOOK_RETRY_DELAY", 1*time.Second),
+			URL:           getEnv("WEBHOOK_URL", ""),
+			AuthKey:       getEnv("WEBHOOK_AUTH_KEY", ""),
+			Timeout:       getDurationEnv("WEBHOOK_TIMEOUT", 30*time.Second),
+			MaxRetries:    getIntEnv("WEBHOOK_MAX_RETRIES", 3),
+			RetryDelay:    getDurationEnv("WEBHOOK_RETRY_DELAY", 1*time.Second),
+			RetryMaxDelay: getDurationEnv("WEBHOOK_RETRY_MAX_DELAY", 30*time.Second),
+			RetryJitter:   getEnv("WEBHOOK_RETRY_JITTER", "full"),
+			RetryBudget:   getDurationEnv("WEBHOOK_RETRY_BUDGET", 1*time.Minute),
+		},
+		Delivery: DeliveryConfig{
+			MaxAttempts:    getIntEnv("DELIVERY_MAX_ATTEMPTS", 5),
+			RetryBaseDelay: getDurationEnv("DELIVERY_RETRY_BASE_DELAY", 1*time.Minute),
+			RetryMaxDelay:  getDurationEnv("DELIVERY_RETRY_MAX_DELAY", 1*time.Hour),
+			Concurrency:    getIntEnv("DELIVERY_CONCURRENCY", 4),
+		},
+		Breaker: BreakerConfig{
+			Enabled:             getBoolEnv("BREAKER_ENABLED", true),
+			ConsecutiveFailures: getIntEnv("BREAKER_CONSECUTIVE_FAILURES", 5),
+			FailureRate:         getFloatEnv("BREAKER_FAILURE_RATE", 0.5),
+			WindowSize:          getIntEnv("BREAKER_WINDOW_SIZE", 20),
+			MinRequests:         getIntEnv("BREAKER_MIN_REQUESTS", 10),
+			OpenTimeout:         getDurationEnv("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+			HalfOpenRequests:    getIntEnv("BREAKER_HALF_OPEN_REQUESTS", 1),
+		},
+		RateLimit: RateLimitConfig{
+			PerSecond: getFloatEnv("RATE_LIMIT_PER_SECOND", 0),
+			Burst:     getIntEnv("RATE_LIMIT_BURST", 1),
+			Shared:    getBoolEnv("RATE_LIMIT_SHARED", false),
+			Key:       getEnv("RATE_LIMIT_KEY", "ratelimit:webhook"),
+		},
+		Callback: CallbackConfig{
+			Secret: getEnv("CALLBACK_SECRET", ""),
+		},
+		Ingest: IngestConfig{
+			MaxBatchSize:      getIntEnv("INGEST_MAX_BATCH_SIZE", 10000),
+			InsertChunkSize:   getIntEnv("INGEST_INSERT_CHUNK_SIZE", 1000),
+			IdempotencyWindow: getDurationEnv("IDEMPOTENCY_WINDOW", 24*time.Hour),
+		},
+		Dedup: DedupConfig{
+			Window: getDurationEnv("DEDUP_WINDOW", 0),
 		},
 	}
 
+	config.Providers = loadProviders(config.Webhook)
+	config.RoutingRules = getEnv("ROUTING_RULES", "")
+
 	return config, nil
 }
 
+// loadProviders reads the providers listed in PROVIDERS, each configured by
+// PROVIDER_<NAME>_* variables. Without PROVIDERS the WEBHOOK_* settings form
+// a single provider named "default".
+func loadProviders(defaults WebhookConfig) []ProviderConfig {
+	names := getEnv("PROVIDERS", "")
+	if names == "" {
+		return []ProviderConfig{{Name: "default", EnvPrefix: "WEBHOOK_", WebhookConfig: defaults}}
+	}
+
+	var providers []ProviderConfig
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "PROVIDER_" + strings.ToUpper(name) + "_"
+		providers = append(providers, ProviderConfig{
+			Name:      name,
+			EnvPrefix: prefix,
+			WebhookConfig: WebhookConfig{
+				URL:           getEnv(prefix+"URL", defaults.URL),
+				AuthKey:       getEnv(prefix+"AUTH_KEY", defaults.AuthKey),
+				Timeout:       getDurationEnv(prefix+"TIMEOUT", defaults.Timeout),
+				MaxRetries:    getIntEnv(prefix+"MAX_RETRIES", defaults.MaxRetries),
+				RetryDelay:    getDurationEnv(prefix+"RETRY_DELAY", defaults.RetryDelay),
+				RetryMaxDelay: getDurationEnv(prefix+"RETRY_MAX_DELAY", defaults.RetryMaxDelay),
+				RetryJitter:   getEnv(prefix+"RETRY_JITTER", defaults.RetryJitter),
+				RetryBudget:   getDurationEnv(prefix+"RETRY_BUDGET", defaults.RetryBudget),
+			},
+		})
+	}
+	return providers
+}
+
 func (c *Config) Validate() error {
-	if c.Webhook.URL == "" {
-		return fmt.Errorf("WEBHOOK_URL is required")
+	if len(c.Providers) == 0 {
+		return fmt.Errorf("PROVIDERS must name at least one provider")
 	}
 
-	if c.Webhook.AuthKey == "" {
-		return fmt.Errorf("WEBHOOK_AUTH_KEY is required")
+	for _, p := range c.Providers {
+		if p.URL == "" {
+			return fmt.Errorf("%sURL is required", p.EnvPrefix)
+		}
+
+		if p.AuthKey == "" {
+			return fmt.Errorf("%sAUTH_KEY is required", p.EnvPrefix)
+		}
+
+		if p.MaxRetries < 0 {
+			return fmt.Errorf("%sMAX_RETRIES cannot be negative", p.EnvPrefix)
+		}
+
+		if p.RetryMaxDelay < p.RetryDelay {
+			return fmt.Errorf("%sRETRY_MAX_DELAY must not be less than %sRETRY_DELAY", p.EnvPrefix, p.EnvPrefix)
+		}
 	}
 
 	if c.Scheduler.BatchSize < 1 {
 		return fmt.Errorf("SCHEDULER_BATCH_SIZE must be at least 1")
 	}
 
+	if c.Scheduler.BatchTimeout <= 0 {
+		return fmt.Errorf("SCHEDULER_BATCH_TIMEOUT must be positive")
+	}
+
+	if c.Scheduler.ClaimLease <= 0 {
+		return fmt.Errorf("SCHEDULER_CLAIM_LEASE must be positive")
+	}
+
+	// Claims must not expire while the batch holding them still sends
+	if c.Scheduler.BatchTimeout >= c.Scheduler.ClaimLease {
+		return fmt.Errorf("SCHEDULER_BATCH_TIMEOUT must be shorter than SCHEDULER_CLAIM_LEASE")
+	}
+
+	if c.Scheduler.LeaderElection && c.Scheduler.LeaderTTL < 3*time.Second {
+		return fmt.Errorf("SCHEDULER_LEADER_TTL must be at least 3s")
+	}
+
+	if _, err := time.LoadLocation(c.Scheduler.Timezone); err != nil {
+		return fmt.Errorf("SCHEDULER_TIMEZONE is invalid: %w", err)
+	}
+
+	if c.Delivery.MaxAttempts < 1 {
+		return fmt.Errorf("DELIVERY_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if c.Delivery.RetryBaseDelay <= 0 || c.Delivery.RetryMaxDelay < c.Delivery.RetryBaseDelay {
+		return fmt.Errorf("DELIVERY_RETRY_BASE_DELAY must be positive and not exceed DELIVERY_RETRY_MAX_DELAY")
+	}
+
+	if c.Delivery.Concurrency < 1 {
+		return fmt.Errorf("DELIVERY_CONCURRENCY must be at least 1")
+	}
+
+	if c.Breaker.Enabled {
+		if c.Breaker.FailureRate < 0 || c.Breaker.FailureRate > 1 {
+			return fmt.Errorf("BREAKER_FAILURE_RATE must be between 0 and 1")
+		}
+
+		if c.Breaker.OpenTimeout <= 0 {
+			return fmt.Errorf("BREAKER_OPEN_TIMEOUT must be positive")
+		}
+	}
+
+	if c.RateLimit.PerSecond < 0 {
+		return fmt.Errorf("RATE_LIMIT_PER_SECOND must not be negative")
+	}
+
+	if c.RateLimit.PerSecond > 0 && c.RateLimit.Burst < 1 {
+		return fmt.Errorf("RATE_LIMIT_BURST must be at least 1")
+	}
+
+	if c.Ingest.MaxBatchSize < 1 {
+		return fmt.Errorf("INGEST_MAX_BATCH_SIZE must be at least 1")
+	}
+
+	if c.Ingest.InsertChunkSize < 1 {
+		return fmt.Errorf("INGEST_INSERT_CHUNK_SIZE must be at least 1")
+	}
+
+	if c.Ingest.IdempotencyWindow <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_WINDOW must be positive")
+	}
+
+	if c.Dedup.Window < 0 {
+		return fmt.Errorf("DEDUP_WINDOW must not be negative")
+	}
+
 	if c.Database.URI == "" {
 		return fmt.Errorf("MONGO_URI is required")
 	}
@@ -107,6 +354,15 @@ func (c *RedisConfig) GetRedisAddr() string {
 	return fmt.Sprintf("%s:%s", c.Host, c.Port)
 }
 
+// defaultInstanceID identifies this process when claiming messages
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "dispatcher"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -132,6 +388,15 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
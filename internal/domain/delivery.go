@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidReceiptStatus = errors.New("receipt status must be one of delivered, undelivered, rejected")
+	ErrMissingReceiptID     = errors.New("receipt messageId is required")
+	// ErrDuplicateReceipt means the message already has the reported status
+	ErrDuplicateReceipt = errors.New("duplicate delivery receipt")
+	// ErrStaleReceipt means the receipt is older than the one already applied
+	ErrStaleReceipt = errors.New("delivery receipt is older than the current status")
+	// ErrReceiptBeforeSent means the receipt arrived before the sent status
+	// was stored; the provider should send it again
+	ErrReceiptBeforeSent = errors.New("message is not recorded as sent yet")
+)
+
+// DeliveryReceipt is a provider's report of what happened to a sent message
+type DeliveryReceipt struct {
+	// Provider names the provider the receipt came from; empty matches any
+	Provider  string
+	MessageID string
+	Status    MessageStatus
+	Timestamp time.Time
+	ErrorCode string
+}
+
+func (r DeliveryReceipt) Validate() error {
+	if r.MessageID == "" {
+		return ErrMissingReceiptID
+	}
+
+	switch r.Status {
+	case StatusDelivered, StatusUndelivered, StatusRejected:
+		return nil
+	}
+	return ErrInvalidReceiptStatus
+}
+
+// ApplyReceipt moves a sent message to the reported status. Receipts that
+// repeat the current status or arrive out of order leave it untouched; a
+// receipt the state machine does not allow returns a TransitionError. A fast
+// provider can report a message before its send is stored, which returns
+// ErrReceiptBeforeSent.
+func (m *Message) ApplyReceipt(r DeliveryReceipt) error {
+	if m.Status == StatusProcessing {
+		return ErrReceiptBeforeSent
+	}
+
+	if m.Status == r.Status {
+		return ErrDuplicateReceipt
+	}
+
+	if m.DeliveryReportedAt != nil && r.Timestamp.Before(*m.DeliveryReportedAt) {
+		return ErrStaleReceipt
+	}
+
+	if err := m.transitionTo(r.Status); err != nil {
+		return err
+	}
+
+	at := r.Timestamp
+	m.DeliveryReportedAt = &at
+	m.DeliveryErrorCode = r.ErrorCode
+	return nil
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessage_ApplyReceipt(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		status  MessageStatus
+		current *time.Time
+		receipt DeliveryReceipt
+		want    MessageStatus
+		wantErr error
+	}{
+		{"sent to delivered", StatusSent, nil, DeliveryReceipt{Status: StatusDelivered, Timestamp: base}, StatusDelivered, nil},
+		{"sent to rejected", StatusSent, nil, DeliveryReceipt{Status: StatusRejected, Timestamp: base}, StatusRejected, nil},
+		{"undelivered then delivered", StatusUndelivered, &base, DeliveryReceipt{Status: StatusDelivered, Timestamp: base.Add(time.Minute)}, StatusDelivered, nil},
+		{"duplicate", StatusDelivered, &base, DeliveryReceipt{Status: StatusDelivered, Timestamp: base}, StatusDelivered, ErrDuplicateReceipt},
+		{"delivered is final", StatusDelivered, &base, DeliveryReceipt{Status: StatusUndelivered, Timestamp: base.Add(time.Minute)}, StatusDelivered, ErrIllegalTransition},
+		{"late receipt", StatusUndelivered, &base, DeliveryReceipt{Status: StatusDelivered, Timestamp: base.Add(-time.Minute)}, StatusUndelivered, ErrStaleReceipt},
+		{"before sent", StatusProcessing, nil, DeliveryReceipt{Status: StatusDelivered, Timestamp: base}, StatusProcessing, ErrReceiptBeforeSent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Status: tt.status, DeliveryReportedAt: tt.current}
+			tt.receipt.ErrorCode = "E1"
+
+			err := msg.ApplyReceipt(tt.receipt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if msg.Status != tt.want {
+				t.Errorf("status = %s, want %s", msg.Status, tt.want)
+			}
+			if tt.wantErr == nil && (msg.DeliveryErrorCode != "E1" || !msg.DeliveryReportedAt.Equal(tt.receipt.Timestamp)) {
+				t.Errorf("receipt details not recorded: %+v", msg)
+			}
+		})
+	}
+}
+
+func TestMessage_ApplyReceiptBeforeSent(t *testing.T) {
+	msg := &Message{Status: StatusProcessing}
+	receipt := DeliveryReceipt{MessageID: "42", Status: StatusDelivered, Timestamp: time.Now()}
+
+	// Not an illegal transition, which would be acknowledged and dropped
+	err := msg.ApplyReceipt(receipt)
+	if !errors.Is(err, ErrReceiptBeforeSent) || errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("err = %v, want ErrReceiptBeforeSent", err)
+	}
+
+	// Redelivered once the send is stored, it applies
+	if err := msg.MarkAsSent("42"); err != nil {
+		t.Fatalf("MarkAsSent: %v", err)
+	}
+	if err := msg.ApplyReceipt(receipt); err != nil || msg.Status != StatusDelivered {
+		t.Errorf("redelivered receipt: %v, status %s, want delivered", err, msg.Status)
+	}
+}
+
+func TestDeliveryReceipt_Validate(t *testing.T) {
+	if err := (DeliveryReceipt{MessageID: "x", Status: StatusSent}).Validate(); !errors.Is(err, ErrInvalidReceiptStatus) {
+		t.Errorf("err = %v, want ErrInvalidReceiptStatus", err)
+	}
+	if err := (DeliveryReceipt{Status: StatusDelivered}).Validate(); !errors.Is(err, ErrMissingReceiptID) {
+		t.Errorf("err = %v, want ErrMissingReceiptID", err)
+	}
+}
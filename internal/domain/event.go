@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// MaxStatusEvents bounds the history kept on each message; older events are dropped
+const MaxStatusEvents = 100
+
+// Actors that change a message's status
+const (
+	ActorScheduler = "scheduler"
+	ActorAPI       = "api"
+	ActorCallback  = "callback"
+)
+
+// SchedulerActor identifies the scheduler instance that changed a message
+func SchedulerActor(instanceID string) string {
+	return ActorScheduler + ":" + instanceID
+}
+
+// StatusEvent records one status change of a message
+type StatusEvent struct {
+	From  MessageStatus `json:"from,omitempty" bson:"from,omitempty"`
+	To    MessageStatus `json:"to" bson:"to"`
+	At    time.Time     `json:"at" bson:"at"`
+	Actor string        `json:"actor" bson:"actor"`
+	// Attempt is the send attempt the change belongs to, counting from 1
+	Attempt          int    `json:"attempt,omitempty" bson:"attempt,omitempty"`
+	Provider         string `json:"provider,omitempty" bson:"provider,omitempty"`
+	ProviderResponse string `json:"provider_response,omitempty" bson:"provider_response,omitempty"`
+	Error            string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Event describes the transition the message just made out of status from
+func (m *Message) Event(from MessageStatus, actor string) StatusEvent {
+	// A failed attempt is counted by RecordFailure; a successful one never is
+	attempt := m.Attempts
+	if m.Status == StatusSent {
+		attempt++
+	}
+
+	return StatusEvent{
+		From:     from,
+		To:       m.Status,
+		At:       time.Now(),
+		Actor:    actor,
+		Attempt:  attempt,
+		Provider: m.Provider,
+	}
+}
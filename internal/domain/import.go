@@ -0,0 +1,212 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrMissingColumn    = errors.New("column not found in the CSV header")
+	ErrUnknownVariable  = errors.New("template variable has no matching column")
+	ErrContentAmbiguous = errors.New("use either a content column or a template, not both")
+	ErrInvalidCSV       = errors.New("invalid CSV")
+)
+
+type ImportStatus string
+
+const (
+	ImportRunning   ImportStatus = "running"
+	ImportCompleted ImportStatus = "completed"
+	// ImportAborted means reading the upload failed part way or the request
+	// was cancelled; rows before that were imported
+	ImportAborted ImportStatus = "aborted"
+)
+
+// Import is one CSV upload and its outcome. Accepted counts the rows created
+// as new messages to send; rows stored as duplicates of a recent message or
+// whose idempotency key was already used count as Suppressed or Replayed.
+type Import struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FileName   string             `json:"file_name,omitempty" bson:"file_name,omitempty"`
+	Status     ImportStatus       `json:"status" bson:"status"`
+	Columns    []string           `json:"columns" bson:"columns"`
+	Rows       int                `json:"rows" bson:"rows"`
+	Accepted   int                `json:"accepted" bson:"accepted"`
+	Suppressed int                `json:"suppressed" bson:"suppressed"`
+	Replayed   int                `json:"replayed" bson:"replayed"`
+	Rejected   int                `json:"rejected" bson:"rejected"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// ImportRowError is a rejected row of an import, kept for the error report
+type ImportRowError struct {
+	ImportID primitive.ObjectID `bson:"import_id"`
+	// Line is the line of the row in the uploaded file, the header being line 1
+	Line   int      `bson:"line"`
+	Values []string `bson:"values"`
+	Error  string   `bson:"error"`
+}
+
+// ImportMapping names the CSV columns each message field is read from.
+// Content comes either from ContentColumn or from Template, whose {{name}}
+// variables are replaced by the row's value in column name.
+type ImportMapping struct {
+	PhoneColumn    string
+	ContentColumn  string
+	SendAtColumn   string
+	PriorityColumn string
+	TagsColumn     string
+	Template       string
+}
+
+// Optional columns are only read when the header has them
+const (
+	DefaultPhoneColumn    = "phone_number"
+	DefaultContentColumn  = "content"
+	DefaultSendAtColumn   = "send_at"
+	DefaultPriorityColumn = "priority"
+	DefaultTagsColumn     = "tags"
+)
+
+var templateVariable = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// ImportColumns is an ImportMapping bound to the header of a file
+type ImportColumns struct {
+	phone     int
+	content   int
+	sendAt    int
+	priority  int
+	tags      int
+	template  string
+	variables map[string]int
+}
+
+// Bind finds the mapped columns in header. Phone number and content, or every
+// template variable, must be present; the other columns are optional unless
+// named explicitly.
+func (m ImportMapping) Bind(header []string) (*ImportColumns, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if i == 0 {
+			// Spreadsheet exports often start with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+
+	find := func(column, fallback string) (int, error) {
+		if column == "" {
+			if fallback == "" {
+				return -1, nil
+			}
+			if i, ok := index[fallback]; ok {
+				return i, nil
+			}
+			return -1, nil
+		}
+		if i, ok := index[column]; ok {
+			return i, nil
+		}
+		return -1, fmt.Errorf("%w: %q", ErrMissingColumn, column)
+	}
+
+	if m.Template != "" && m.ContentColumn != "" {
+		return nil, ErrContentAmbiguous
+	}
+
+	columns := &ImportColumns{template: m.Template}
+	var err error
+
+	phoneColumn := m.PhoneColumn
+	if phoneColumn == "" {
+		phoneColumn = DefaultPhoneColumn
+	}
+	if columns.phone, err = find(phoneColumn, ""); err != nil {
+		return nil, err
+	}
+
+	if m.Template != "" {
+		columns.content = -1
+		columns.variables = map[string]int{}
+		for _, match := range templateVariable.FindAllStringSubmatch(m.Template, -1) {
+			i, ok := index[match[1]]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownVariable, match[1])
+			}
+			columns.variables[match[1]] = i
+		}
+	} else {
+		contentColumn := m.ContentColumn
+		if contentColumn == "" {
+			contentColumn = DefaultContentColumn
+		}
+		if columns.content, err = find(contentColumn, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if columns.sendAt, err = find(m.SendAtColumn, DefaultSendAtColumn); err != nil {
+		return nil, err
+	}
+	if columns.priority, err = find(m.PriorityColumn, DefaultPriorityColumn); err != nil {
+		return nil, err
+	}
+	if columns.tags, err = find(m.TagsColumn, DefaultTagsColumn); err != nil {
+		return nil, err
+	}
+
+	return columns, nil
+}
+
+// Message builds the message of one row. It only parses the row; the result
+// still has to pass Validate. Send times are RFC 3339, tags are separated by
+// "|" or ";".
+func (c *ImportColumns) Message(record []string) (*Message, error) {
+	value := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	message := &Message{
+		PhoneNumber: value(c.phone),
+		Content:     value(c.content),
+		Priority:    MessagePriority(strings.ToLower(value(c.priority))),
+	}
+
+	if c.template != "" {
+		message.Content = templateVariable.ReplaceAllStringFunc(c.template, func(match string) string {
+			name := templateVariable.FindStringSubmatch(match)[1]
+			return value(c.variables[name])
+		})
+	}
+
+	if raw := value(c.sendAt); raw != "" {
+		sendAt, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("send_at must be an RFC 3339 time: %q", raw)
+		}
+		message.SendAt = &sendAt
+	}
+
+	if raw := value(c.tags); raw != "" {
+		for _, tag := range strings.FieldsFunc(raw, func(r rune) bool { return r == '|' || r == ';' }) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				message.Tags = append(message.Tags, tag)
+			}
+		}
+	}
+
+	return message, nil
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestImportMapping_Bind(t *testing.T) {
+	header := []string{"\ufeffphone_number", "name", "code", "content", "tags"}
+
+	tests := []struct {
+		name    string
+		mapping ImportMapping
+		wantErr error
+	}{
+		{"defaults", ImportMapping{}, nil},
+		{"template", ImportMapping{Template: "Hi {{name}}, your code is {{ code }}"}, nil},
+		{"unknown variable", ImportMapping{Template: "Hi {{surname}}"}, ErrUnknownVariable},
+		{"missing phone", ImportMapping{PhoneColumn: "msisdn"}, ErrMissingColumn},
+		{"missing explicit optional", ImportMapping{SendAtColumn: "when"}, ErrMissingColumn},
+		{"content and template", ImportMapping{ContentColumn: "content", Template: "x"}, ErrContentAmbiguous},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.mapping.Bind(header)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImportColumns_Message(t *testing.T) {
+	header := []string{"msisdn", "name", "when", "tags"}
+	columns, err := ImportMapping{
+		PhoneColumn:  "msisdn",
+		SendAtColumn: "when",
+		Template:     "Hi {{name}}!",
+	}.Bind(header)
+	if err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+
+	msg, err := columns.Message([]string{" +905551234567 ", "Ada", "2030-01-02T09:00:00Z", "vip|spring ; promo"})
+	if err != nil {
+		t.Fatalf("Message: %v", err)
+	}
+	if msg.PhoneNumber != "+905551234567" || msg.Content != "Hi Ada!" {
+		t.Errorf("message = %+v", msg)
+	}
+	if msg.SendAt == nil || msg.SendAt.Year() != 2030 {
+		t.Errorf("send_at = %v, want 2030-01-02", msg.SendAt)
+	}
+	if want := []string{"vip", "spring", "promo"}; !reflect.DeepEqual(msg.Tags, want) {
+		t.Errorf("tags = %v, want %v", msg.Tags, want)
+	}
+
+	// A short row reads its missing columns as empty
+	if msg, err := columns.Message([]string{"+905551234567"}); err != nil || msg.Content != "Hi !" {
+		t.Errorf("short row: message = %+v, err = %v", msg, err)
+	}
+
+	if _, err := columns.Message([]string{"+905551234567", "Ada", "tomorrow"}); err == nil {
+		t.Error("invalid send_at accepted")
+	}
+}
@@ -10,27 +10,130 @@ import (
 type MessageStatus string
 
 const (
-	StatusPending MessageStatus = "pending"
-	StatusSent    MessageStatus = "sent"
-	StatusFailed  MessageStatus = "failed"
+	StatusPending    MessageStatus = "pending"
+	StatusProcessing MessageStatus = "processing"
+	StatusSent       MessageStatus = "sent"
+	// StatusFailed is no longer set; a failed send is retried or dead-lettered.
+	// Messages stored with it before retries existed can still be requeued.
+	StatusFailed     MessageStatus = "failed"
+	StatusExpired    MessageStatus = "expired"
+	StatusDeadLetter MessageStatus = "dead_letter"
+	StatusCancelled  MessageStatus = "cancelled"
+	// StatusSuppressed marks a duplicate of a recent message to the same recipient
+	StatusSuppressed MessageStatus = "suppressed"
+
+	// Reported by the provider after the message was sent
+	StatusDelivered   MessageStatus = "delivered"
+	StatusUndelivered MessageStatus = "undelivered"
+	StatusRejected    MessageStatus = "rejected"
+)
+
+// SentStatuses are the statuses of messages the provider has accepted
+var SentStatuses = []MessageStatus{StatusSent, StatusDelivered, StatusUndelivered, StatusRejected}
+
+func (s MessageStatus) IsValid() bool {
+	switch s {
+	case StatusPending, StatusProcessing, StatusSent, StatusFailed, StatusExpired, StatusDeadLetter, StatusCancelled,
+		StatusSuppressed, StatusDelivered, StatusUndelivered, StatusRejected:
+		return true
+	}
+	return false
+}
+
+type MessagePriority string
+
+const (
+	PriorityCritical MessagePriority = "critical"
+	PriorityHigh     MessagePriority = "high"
+	PriorityNormal   MessagePriority = "normal"
+	PriorityBulk     MessagePriority = "bulk"
 )
 
+// Rank orders priorities for claiming; higher ranks are sent first
+func (p MessagePriority) Rank() int {
+	switch p {
+	case PriorityCritical:
+		return 3
+	case PriorityHigh:
+		return 2
+	case PriorityNormal:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (p MessagePriority) IsValid() bool {
+	switch p {
+	case PriorityCritical, PriorityHigh, PriorityNormal, PriorityBulk:
+		return true
+	}
+	return false
+}
+
 const MaxMessageLength = 160
 
+const (
+	MaxTags      = 10
+	MaxTagLength = 50
+
+	MaxExternalIDLength = 255
+)
+
+const (
+	// MaxSendAtPast tolerates client clock skew for send times slightly in the past
+	MaxSendAtPast = 5 * time.Minute
+	// MaxSendAtFuture limits how far ahead a message can be scheduled
+	MaxSendAtFuture = 90 * 24 * time.Hour
+)
+
 var (
 	ErrMessageTooLong     = errors.New("message content exceeds maximum length")
 	ErrInvalidPhoneNumber = errors.New("invalid phone number")
 	ErrEmptyContent       = errors.New("message content cannot be empty")
+	ErrSendAtTooEarly     = errors.New("send_at is too far in the past")
+	ErrSendAtTooLate      = errors.New("send_at is too far in the future")
+	ErrInvalidPriority    = errors.New("priority must be one of critical, high, normal, bulk")
+	ErrAlreadyExpired     = errors.New("expires_at must be in the future")
+	ErrExpiresBeforeSend  = errors.New("expires_at must be after send_at")
+	ErrTooManyTags        = errors.New("a message can have at most 10 tags")
+	ErrInvalidTag         = errors.New("tags must be 1 to 50 characters")
+	ErrInvalidExternalID  = errors.New("external_id must be at most 255 characters")
+	// ErrIdempotencyConflict means an idempotency key was reused for a
+	// different message within the replay window
+	ErrIdempotencyConflict = errors.New("idempotency key was already used for a different message")
 )
 
 type Message struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	PhoneNumber string             `json:"phone_number" bson:"phone_number"`
-	Content     string             `json:"content" bson:"content"`
-	Status      MessageStatus      `json:"status" bson:"status"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	SentAt      *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
-	MessageID   *string            `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	ID                 primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	PhoneNumber        string             `json:"phone_number" bson:"phone_number"`
+	Content            string             `json:"content" bson:"content"`
+	Status             MessageStatus      `json:"status" bson:"status"`
+	CreatedAt          time.Time          `json:"created_at" bson:"created_at"`
+	SentAt             *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	MessageID          *string            `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	Provider           string             `json:"provider,omitempty" bson:"provider,omitempty"`
+	DeliveryReportedAt *time.Time         `json:"delivery_reported_at,omitempty" bson:"delivery_reported_at,omitempty"`
+	DeliveryErrorCode  string             `json:"delivery_error_code,omitempty" bson:"delivery_error_code,omitempty"`
+	SendAt             *time.Time         `json:"send_at,omitempty" bson:"send_at,omitempty"`
+	Priority           MessagePriority    `json:"priority,omitempty" bson:"priority,omitempty"`
+	Tags               []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	// ExternalID is the client's idempotency key, unique among messages
+	ExternalID   string     `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	PriorityRank int        `json:"-" bson:"priority_rank"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	ExpiredAt    *time.Time `json:"expired_at,omitempty" bson:"expired_at,omitempty"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+	SuppressedAt *time.Time `json:"suppressed_at,omitempty" bson:"suppressed_at,omitempty"`
+	// SuppressionReason says which message a suppressed message duplicates
+	SuppressionReason string     `json:"suppression_reason,omitempty" bson:"suppression_reason,omitempty"`
+	Attempts          int        `json:"attempts" bson:"attempts"`
+	LastError         string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt     *time.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	ClaimedBy         *string    `json:"claimed_by,omitempty" bson:"claimed_by,omitempty"`
+	LeaseExpiresAt    *time.Time `json:"lease_expires_at,omitempty" bson:"lease_expires_at,omitempty"`
+	// Events is the status history, served separately by the events endpoint
+	Events []StatusEvent `json:"-" bson:"events,omitempty"`
 }
 
 func (m *Message) Validate() error {
@@ -46,16 +149,138 @@ func (m *Message) Validate() error {
 		return ErrInvalidPhoneNumber
 	}
 
+	if m.Priority != "" && !m.Priority.IsValid() {
+		return ErrInvalidPriority
+	}
+
+	if len(m.Tags) > MaxTags {
+		return ErrTooManyTags
+	}
+
+	for _, tag := range m.Tags {
+		if tag == "" || len(tag) > MaxTagLength {
+			return ErrInvalidTag
+		}
+	}
+
+	if len(m.ExternalID) > MaxExternalIDLength {
+		return ErrInvalidExternalID
+	}
+
+	return nil
+}
+
+// ApplyDefaults fills in the priority of new messages and the rank derived from it
+func (m *Message) ApplyDefaults() {
+	if m.Priority == "" {
+		m.Priority = PriorityNormal
+	}
+	m.PriorityRank = m.Priority.Rank()
+}
+
+// ValidateSendAt checks that a requested send time is within the allowed
+// scheduling window. It is only applied when a message is created.
+func (m *Message) ValidateSendAt(now time.Time) error {
+	if m.SendAt == nil {
+		return nil
+	}
+
+	if m.SendAt.Before(now.Add(-MaxSendAtPast)) {
+		return ErrSendAtTooEarly
+	}
+
+	if m.SendAt.After(now.Add(MaxSendAtFuture)) {
+		return ErrSendAtTooLate
+	}
+
+	return nil
+}
+
+// ValidateExpiry checks that an expiry given at creation can still be met
+func (m *Message) ValidateExpiry(now time.Time) error {
+	if m.ExpiresAt == nil {
+		return nil
+	}
+
+	if !m.ExpiresAt.After(now) {
+		return ErrAlreadyExpired
+	}
+
+	if m.SendAt != nil && !m.ExpiresAt.After(*m.SendAt) {
+		return ErrExpiresBeforeSend
+	}
+
 	return nil
 }
 
-func (m *Message) MarkAsSent(messageID string) {
+// IsExpired reports whether the message missed its delivery window
+func (m *Message) IsExpired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+func (m *Message) MarkAsSent(messageID string) error {
+	if err := m.transitionTo(StatusSent); err != nil {
+		return err
+	}
+
 	now := time.Now()
-	m.Status = StatusSent
 	m.SentAt = &now
 	m.MessageID = &messageID
+	return nil
+}
+
+func (m *Message) MarkAsExpired() error {
+	if err := m.transitionTo(StatusExpired); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.ExpiredAt = &now
+	return nil
+}
+
+// MarkAsSuppressed keeps a duplicate from being sent, recording why
+func (m *Message) MarkAsSuppressed(reason string) error {
+	if err := m.transitionTo(StatusSuppressed); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.SuppressedAt = &now
+	m.SuppressionReason = reason
+	return nil
+}
+
+// RecordFailure counts a failed send attempt and keeps its reason
+func (m *Message) RecordFailure(err error) {
+	m.Attempts++
+	m.LastError = err.Error()
+}
+
+// ScheduleRetry returns the message to the pending pool, not to be claimed before at
+func (m *Message) ScheduleRetry(at time.Time) error {
+	if err := m.transitionTo(StatusPending); err != nil {
+		return err
+	}
+
+	m.NextAttemptAt = &at
+	return nil
+}
+
+// MarkAsDeadLetter parks a message that exhausted its attempts until it is requeued
+func (m *Message) MarkAsDeadLetter() error {
+	if err := m.transitionTo(StatusDeadLetter); err != nil {
+		return err
+	}
+
+	m.NextAttemptAt = nil
+	return nil
 }
 
-func (m *Message) MarkAsFailed() {
-	m.Status = StatusFailed
+// ReleaseClaim forgets the scheduler claim on the message. The transitions
+// out of processing keep the claim so that storing them can be fenced on it;
+// it is released once the new status is stored.
+func (m *Message) ReleaseClaim() {
+	m.ClaimedBy = nil
+	m.LeaseExpiresAt = nil
 }
@@ -1,8 +1,10 @@
 package domain
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMessage_Validate(t *testing.T) {
@@ -43,6 +45,24 @@ func TestMessage_Validate(t *testing.T) {
 			},
 			wantErr: ErrMessageTooLong,
 		},
+		{
+			name: "invalid priority",
+			message: Message{
+				PhoneNumber: "+905551111111",
+				Content:     "Test",
+				Priority:    "urgent",
+			},
+			wantErr: ErrInvalidPriority,
+		},
+		{
+			name: "empty tag",
+			message: Message{
+				PhoneNumber: "+905551111111",
+				Content:     "Test",
+				Tags:        []string{"promo", ""},
+			},
+			wantErr: ErrInvalidTag,
+		},
 	}
 
 	for _, tt := range tests {
@@ -59,11 +79,13 @@ func TestMessage_MarkAsSent(t *testing.T) {
 	msg := &Message{
 		PhoneNumber: "+905551111111",
 		Content:     "Test",
-		Status:      StatusPending,
+		Status:      StatusProcessing,
 	}
 
 	messageID := "test-id"
-	msg.MarkAsSent(messageID)
+	if err := msg.MarkAsSent(messageID); err != nil {
+		t.Fatalf("MarkAsSent: %v", err)
+	}
 
 	if msg.Status != StatusSent {
 		t.Errorf("status = %s, want %s", msg.Status, StatusSent)
@@ -77,3 +99,135 @@ func TestMessage_MarkAsSent(t *testing.T) {
 		t.Error("sentAt not set")
 	}
 }
+
+func TestMessage_MarkAsSuppressed(t *testing.T) {
+	owner := "scheduler-1"
+	msg := &Message{Status: StatusProcessing, ClaimedBy: &owner}
+
+	if err := msg.MarkAsSuppressed("duplicate of message abc"); err != nil {
+		t.Fatalf("MarkAsSuppressed: %v", err)
+	}
+
+	if msg.Status != StatusSuppressed || msg.SuppressedAt == nil {
+		t.Errorf("message = %+v, want suppressed with a time", msg)
+	}
+	if msg.SuppressionReason != "duplicate of message abc" {
+		t.Errorf("reason = %q", msg.SuppressionReason)
+	}
+	// The claim fences the write of the new status and is released after it
+	if msg.ClaimedBy == nil || *msg.ClaimedBy != owner {
+		t.Error("claim dropped before the new status was stored")
+	}
+
+	if err := msg.MarkAsSuppressed("again"); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("second MarkAsSuppressed: err = %v, want ErrIllegalTransition", err)
+	}
+}
+
+func TestMessage_ValidateSendAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		sendAt  *time.Time
+		wantErr error
+	}{
+		{name: "not scheduled", sendAt: nil, wantErr: nil},
+		{name: "tomorrow", sendAt: at(24 * time.Hour), wantErr: nil},
+		{name: "slightly in the past", sendAt: at(-time.Minute), wantErr: nil},
+		{name: "too far in the past", sendAt: at(-time.Hour), wantErr: ErrSendAtTooEarly},
+		{name: "too far in the future", sendAt: at(MaxSendAtFuture + time.Hour), wantErr: ErrSendAtTooLate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{SendAt: tt.sendAt}
+			if err := msg.ValidateSendAt(now); err != tt.wantErr {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessage_ApplyDefaults(t *testing.T) {
+	msg := &Message{}
+	msg.ApplyDefaults()
+
+	if msg.Priority != PriorityNormal {
+		t.Errorf("priority = %s, want %s", msg.Priority, PriorityNormal)
+	}
+
+	critical := &Message{Priority: PriorityCritical}
+	critical.ApplyDefaults()
+
+	if critical.PriorityRank <= msg.PriorityRank {
+		t.Errorf("critical rank %d should exceed normal rank %d", critical.PriorityRank, msg.PriorityRank)
+	}
+}
+
+func TestMessage_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+	later := now.Add(2 * time.Hour)
+
+	tests := []struct {
+		name        string
+		message     Message
+		wantErr     error
+		wantExpired bool
+	}{
+		{name: "no expiry", message: Message{}, wantErr: nil, wantExpired: false},
+		{name: "expires in the future", message: Message{ExpiresAt: &future}, wantErr: nil, wantExpired: false},
+		{name: "already expired", message: Message{ExpiresAt: &past}, wantErr: ErrAlreadyExpired, wantExpired: true},
+		{name: "expires before send", message: Message{SendAt: &later, ExpiresAt: &future}, wantErr: ErrExpiresBeforeSend, wantExpired: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.message.ValidateExpiry(now); err != tt.wantErr {
+				t.Errorf("ValidateExpiry = %v, want %v", err, tt.wantErr)
+			}
+			if got := tt.message.IsExpired(now); got != tt.wantExpired {
+				t.Errorf("IsExpired = %v, want %v", got, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestMessage_RetryAndDeadLetter(t *testing.T) {
+	owner := "instance-1"
+	msg := &Message{Status: StatusProcessing, ClaimedBy: &owner}
+
+	msg.RecordFailure(errors.New("boom"))
+	next := time.Now().Add(time.Minute)
+	if err := msg.ScheduleRetry(next); err != nil {
+		t.Fatalf("ScheduleRetry: %v", err)
+	}
+
+	if msg.Status != StatusPending || msg.Attempts != 1 || msg.LastError != "boom" {
+		t.Errorf("unexpected message after retry: %+v", msg)
+	}
+	if msg.NextAttemptAt == nil || !msg.NextAttemptAt.Equal(next) {
+		t.Error("next attempt not set")
+	}
+	// The claim fences the write of the new status and is released after it
+	if msg.ClaimedBy == nil || *msg.ClaimedBy != owner {
+		t.Error("claim dropped before the new status was stored")
+	}
+
+	// Claimed again for the second attempt
+	msg.Status = StatusProcessing
+	msg.RecordFailure(errors.New("boom again"))
+	if err := msg.MarkAsDeadLetter(); err != nil {
+		t.Fatalf("MarkAsDeadLetter: %v", err)
+	}
+
+	if msg.Status != StatusDeadLetter || msg.Attempts != 2 || msg.NextAttemptAt != nil {
+		t.Errorf("unexpected message after dead-letter: %+v", msg)
+	}
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MinSchedulerInterval = time.Second
+	MaxSchedulerBatch    = 1000
+)
+
+var (
+	ErrInvalidInterval     = errors.New("interval must be at least 1s")
+	ErrInvalidBatchSize    = errors.New("batch size must be between 1 and 1000")
+	ErrInvalidBatchTimeout = errors.New("batch timeout must be positive")
+	// ErrBatchTimeoutTooLong means claims could expire while a batch still
+	// sends them, letting another replica send them again
+	ErrBatchTimeoutTooLong = errors.New("batch timeout must be shorter than the claim lease")
+)
+
+// SchedulerSettings holds the scheduler values that can be changed at runtime
+type SchedulerSettings struct {
+	Interval     time.Duration `json:"interval" bson:"interval"`
+	BatchSize    int           `json:"batch_size" bson:"batch_size"`
+	BatchTimeout time.Duration `json:"batch_timeout" bson:"batch_timeout"`
+	UpdatedAt    time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+func (s *SchedulerSettings) Validate() error {
+	if s.Interval < MinSchedulerInterval {
+		return ErrInvalidInterval
+	}
+
+	if s.BatchSize < 1 || s.BatchSize > MaxSchedulerBatch {
+		return ErrInvalidBatchSize
+	}
+
+	if s.BatchTimeout <= 0 {
+		return ErrInvalidBatchTimeout
+	}
+
+	return nil
+}
+
+// ValidateLease checks that a batch ends before the leases on the messages it
+// claimed run out. A zero lease is not checked.
+func (s *SchedulerSettings) ValidateLease(claimLease time.Duration) error {
+	if claimLease > 0 && s.BatchTimeout >= claimLease {
+		return fmt.Errorf("%w (%v)", ErrBatchTimeoutTooLong, claimLease)
+	}
+	return nil
+}
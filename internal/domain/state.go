@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIllegalTransition matches every TransitionError
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// TransitionError reports a status change the state machine does not allow
+type TransitionError struct {
+	From MessageStatus
+	To   MessageStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("illegal status transition from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// transitions lists every allowed status change. A message is claimed from
+// pending into processing unless it is cancelled first, and leaves processing
+// once per attempt: sent, back to pending for a retry or release, or into one
+// of the terminal failure states. A duplicate is suppressed when created or
+// just before it would be sent. Only the provider moves a message past sent.
+// Undelivered is the one terminal status that can still change: carriers
+// report it while the handset is unreachable and keep retrying, so a later
+// delivered receipt is the final word. Nothing enters failed any more;
+// messages stored with it by older versions can still be requeued or
+// dead-lettered.
+var transitions = map[MessageStatus][]MessageStatus{
+	StatusPending:     {StatusProcessing, StatusCancelled, StatusSuppressed},
+	StatusProcessing:  {StatusSent, StatusPending, StatusExpired, StatusDeadLetter, StatusSuppressed},
+	StatusFailed:      {StatusPending, StatusDeadLetter},
+	StatusDeadLetter:  {StatusPending},
+	StatusSent:        {StatusDelivered, StatusUndelivered, StatusRejected},
+	StatusUndelivered: {StatusDelivered},
+}
+
+// CanTransition reports whether a message may move from one status to another
+func CanTransition(from, to MessageStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionTo moves the message to status, or returns a TransitionError
+// and leaves it untouched
+func (m *Message) transitionTo(status MessageStatus) error {
+	if !CanTransition(m.Status, status) {
+		return &TransitionError{From: m.Status, To: status}
+	}
+	m.Status = status
+	return nil
+}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to MessageStatus
+		want     bool
+	}{
+		{StatusPending, StatusProcessing, true},
+		{StatusProcessing, StatusSent, true},
+		{StatusProcessing, StatusPending, true},
+		{StatusDeadLetter, StatusPending, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusSent, StatusDelivered, true},
+		{StatusPending, StatusSent, false},
+		{StatusFailed, StatusSent, false},
+		{StatusSent, StatusPending, false},
+		{StatusExpired, StatusProcessing, false},
+		{StatusDelivered, StatusUndelivered, false},
+		{StatusProcessing, StatusCancelled, false},
+		{StatusCancelled, StatusPending, false},
+		{StatusPending, StatusSuppressed, true},
+		{StatusProcessing, StatusSuppressed, true},
+		{StatusSuppressed, StatusPending, false},
+		{StatusSent, StatusSuppressed, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestMessage_IllegalTransition(t *testing.T) {
+	msg := &Message{Status: StatusFailed}
+
+	err := msg.MarkAsSent("late-id")
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("err = %v, want ErrIllegalTransition", err)
+	}
+
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != StatusFailed || transitionErr.To != StatusSent {
+		t.Errorf("err = %#v, want failed to sent", err)
+	}
+	if msg.Status != StatusFailed || msg.MessageID != nil || msg.SentAt != nil {
+		t.Errorf("message changed by illegal transition: %+v", msg)
+	}
+
+	if err := msg.ScheduleRetry(time.Now()); err != nil {
+		t.Errorf("ScheduleRetry from failed: %v", err)
+	}
+}
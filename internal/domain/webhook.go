@@ -8,4 +8,6 @@ type WebhookRequest struct {
 type WebhookResponse struct {
 	Message   string `json:"message"`
 	MessageID string `json:"messageId"`
+	// Provider is the name of the provider that accepted the message
+	Provider string `json:"-"`
 }
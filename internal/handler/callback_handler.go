@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+)
+
+// maxCallbackBody bounds the size of a delivery receipt
+const maxCallbackBody = 64 << 10
+
+// receiptRetryAfter is the Retry-After, in seconds, of a receipt that arrived
+// before the send it reports on was stored
+const receiptRetryAfter = 5
+
+type CallbackHandler struct {
+	messageService service.MessageService
+	secret         string
+}
+
+// NewCallbackHandler creates the handler. An empty secret disables the
+// callback endpoints.
+func NewCallbackHandler(messageService service.MessageService, secret string) *CallbackHandler {
+	return &CallbackHandler{
+		messageService: messageService,
+		secret:         secret,
+	}
+}
+
+// DeliveryReceiptRequest is the body the provider posts for each receipt
+type DeliveryReceiptRequest struct {
+	// Provider names the provider sending the receipt; it may also be given
+	// as the provider query parameter of the callback URL
+	Provider  string               `json:"provider,omitempty"`
+	MessageID string               `json:"messageId"`
+	Status    domain.MessageStatus `json:"status"`
+	// Timestamp is when the provider observed the status; defaults to now
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	ErrorCode string     `json:"errorCode,omitempty"`
+}
+
+// Delivery accepts a delivery receipt. Duplicate and out-of-order receipts are
+// acknowledged with 200 so the provider stops resending them, but change nothing.
+func (h *CallbackHandler) Delivery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.secret == "" {
+		h.sendError(w, "Delivery callbacks are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+	if err != nil {
+		h.sendError(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorized(r, body) {
+		h.sendError(w, "Invalid callback signature", http.StatusUnauthorized)
+		return
+	}
+
+	var req DeliveryReceiptRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Provider == "" {
+		req.Provider = r.URL.Query().Get("provider")
+	}
+
+	receipt := domain.DeliveryReceipt{
+		Provider:  req.Provider,
+		MessageID: req.MessageID,
+		Status:    domain.MessageStatus(strings.ToLower(string(req.Status))),
+		Timestamp: time.Now(),
+		ErrorCode: req.ErrorCode,
+	}
+	if req.Timestamp != nil {
+		receipt.Timestamp = *req.Timestamp
+	}
+
+	message, err := h.messageService.RecordDeliveryReceipt(r.Context(), receipt)
+	if errors.Is(err, domain.ErrReceiptBeforeSent) {
+		// Acknowledging it would lose the receipt; the provider retries a 503
+		w.Header().Set("Retry-After", strconv.Itoa(receiptRetryAfter))
+		h.sendError(w, "Message is not recorded as sent yet, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, domain.ErrDuplicateReceipt) || errors.Is(err, domain.ErrStaleReceipt) || errors.Is(err, domain.ErrIllegalTransition) {
+		h.sendJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Receipt ignored: " + err.Error(),
+			Data:    message,
+		})
+		return
+	}
+	if err != nil {
+		h.sendError(w, err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Receipt recorded",
+		Data:    message,
+	})
+}
+
+// authorized accepts either an X-Signature header holding the hex HMAC-SHA256
+// of the body, optionally prefixed with "sha256=", or the shared secret itself
+// in X-Callback-Secret
+func (h *CallbackHandler) authorized(r *http.Request, body []byte) bool {
+	if signature := r.Header.Get("X-Signature"); signature != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha256.New, []byte(h.secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+
+	secret := r.Header.Get("X-Callback-Secret")
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) == 1
+}
+
+func (h *CallbackHandler) sendJSON(w http.ResponseWriter, statusCode int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *CallbackHandler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	h.sendJSON(w, statusCode, Response{
+		Success: false,
+		Message: message,
+	})
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+)
+
+// receiptService records the receipts it gets and answers with err
+type receiptService struct {
+	service.MessageService
+
+	receipts []domain.DeliveryReceipt
+	err      error
+}
+
+func (s *receiptService) RecordDeliveryReceipt(ctx context.Context, receipt domain.DeliveryReceipt) (*domain.Message, error) {
+	s.receipts = append(s.receipts, receipt)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &domain.Message{Status: receipt.Status}, nil
+}
+
+const receiptBody = `{"messageId":"42","status":"delivered"}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postReceipt(h *CallbackHandler, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.Delivery(rec, req)
+	return rec
+}
+
+func TestDelivery_Authentication(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "valid signature", headers: map[string]string{"X-Signature": sign("s3cret", receiptBody)}, want: http.StatusOK},
+		{name: "signature of another body", headers: map[string]string{"X-Signature": sign("s3cret", "{}")}, want: http.StatusUnauthorized},
+		{name: "signature with another secret", headers: map[string]string{"X-Signature": sign("other", receiptBody)}, want: http.StatusUnauthorized},
+		{name: "malformed signature", headers: map[string]string{"X-Signature": "sha256=zz"}, want: http.StatusUnauthorized},
+		{name: "shared secret", headers: map[string]string{"X-Callback-Secret": "s3cret"}, want: http.StatusOK},
+		{name: "wrong shared secret", headers: map[string]string{"X-Callback-Secret": "guess"}, want: http.StatusUnauthorized},
+		{name: "no credentials", headers: nil, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		svc := &receiptService{}
+		rec := postReceipt(NewCallbackHandler(svc, "s3cret"), "/api/callbacks/delivery", receiptBody, tt.headers)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want != http.StatusOK && len(svc.receipts) != 0 {
+			t.Errorf("%s: receipt recorded without authentication", tt.name)
+		}
+	}
+}
+
+func TestDelivery_DisabledWithoutSecret(t *testing.T) {
+	svc := &receiptService{}
+	rec := postReceipt(NewCallbackHandler(svc, ""), "/api/callbacks/delivery", receiptBody, map[string]string{"X-Callback-Secret": ""})
+
+	if rec.Code != http.StatusServiceUnavailable || len(svc.receipts) != 0 {
+		t.Errorf("status = %d, want 503 without recording", rec.Code)
+	}
+}
+
+func TestDelivery_Provider(t *testing.T) {
+	auth := map[string]string{"X-Callback-Secret": "s3cret"}
+
+	svc := &receiptService{}
+	h := NewCallbackHandler(svc, "s3cret")
+	postReceipt(h, "/api/callbacks/delivery?provider=backup", receiptBody, auth)
+	postReceipt(h, "/api/callbacks/delivery?provider=backup", `{"provider":"primary","messageId":"42","status":"delivered"}`, auth)
+
+	if len(svc.receipts) != 2 || svc.receipts[0].Provider != "backup" || svc.receipts[1].Provider != "primary" {
+		t.Errorf("receipts = %+v, want the query provider and then the body one", svc.receipts)
+	}
+}
+
+func TestDelivery_ReceiptOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       int
+		retryAfter bool
+	}{
+		{name: "duplicate", err: domain.ErrDuplicateReceipt, want: http.StatusOK},
+		{name: "illegal", err: &domain.TransitionError{From: domain.StatusDelivered, To: domain.StatusUndelivered}, want: http.StatusOK},
+		{name: "before sent", err: domain.ErrReceiptBeforeSent, want: http.StatusServiceUnavailable, retryAfter: true},
+		{name: "invalid status", err: domain.ErrInvalidReceiptStatus, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		h := NewCallbackHandler(&receiptService{err: tt.err}, "s3cret")
+		rec := postReceipt(h, "/api/callbacks/delivery", receiptBody, map[string]string{"X-Callback-Secret": "s3cret"})
+
+		if rec.Code != tt.want || (rec.Header().Get("Retry-After") != "") != tt.retryAfter {
+			t.Errorf("%s: status = %d Retry-After %q, want %d", tt.name, rec.Code, rec.Header().Get("Retry-After"), tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+)
+
+type HealthHandler struct {
+	router *service.Router
+}
+
+func NewHealthHandler(router *service.Router) *HealthHandler {
+	return &HealthHandler{
+		router: router,
+	}
+}
+
+// Health reports "degraded" while any provider circuit is not closed. The
+// service itself is still up, so the status code stays 200.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providers := h.router.Status()
+	status := "ok"
+	for _, p := range providers {
+		if p.CircuitBreaker != nil && p.CircuitBreaker.State != service.BreakerClosed {
+			status = "degraded"
+		}
+	}
+
+	h.sendJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"status":    status,
+			"providers": providers,
+		},
+	})
+}
+
+func (h *HealthHandler) sendJSON(w http.ResponseWriter, statusCode int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *HealthHandler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	h.sendJSON(w, statusCode, Response{
+		Success: false,
+		Message: message,
+	})
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ImportHandler struct {
+	importService service.ImportService
+	logger        *logger.Logger
+}
+
+func NewImportHandler(importService service.ImportService, logger *logger.Logger) *ImportHandler {
+	return &ImportHandler{
+		importService: importService,
+		logger:        logger,
+	}
+}
+
+// Import serves CSV imports:
+//
+//	POST /api/messages/import
+//	GET  /api/messages/import/{id}
+//	GET  /api/messages/import/{id}/errors
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/messages/import"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "":
+		h.upload(w, r)
+	case len(parts) == 1:
+		h.getImport(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "errors":
+		h.downloadErrors(w, r, parts[0])
+	default:
+		h.sendError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// upload imports a CSV sent as the "file" field of a multipart form or as a
+// text/csv body. The column mapping comes from the query string.
+func (h *ImportHandler) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, fileName, err := csvBody(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Large files take longer than the server timeouts allow
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	query := r.URL.Query()
+	mapping := domain.ImportMapping{
+		PhoneColumn:    query.Get("phone_column"),
+		ContentColumn:  query.Get("content_column"),
+		SendAtColumn:   query.Get("send_at_column"),
+		PriorityColumn: query.Get("priority_column"),
+		TagsColumn:     query.Get("tags_column"),
+		Template:       query.Get("template"),
+	}
+
+	imp, err := h.importService.Import(r.Context(), body, fileName, mapping)
+	if err != nil {
+		h.sendError(w, "Failed to import: "+err.Error(), importStatusForError(err))
+		return
+	}
+
+	data := map[string]interface{}{"import": imp}
+	if imp.Rejected > 0 {
+		data["error_report"] = fmt.Sprintf("/api/messages/import/%s/errors", imp.ID.Hex())
+	}
+
+	h.sendJSON(w, http.StatusCreated, Response{
+		Success: imp.Status == domain.ImportCompleted,
+		Message: fmt.Sprintf("accepted %d, rejected %d", imp.Accepted, imp.Rejected),
+		Data:    data,
+	})
+}
+
+// csvBody returns the uploaded file without reading it into memory
+func csvBody(r *http.Request) (io.Reader, string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", errors.New("Content-Type must be multipart/form-data or text/csv")
+	}
+
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return r.Body, "", nil
+	case "multipart/form-data":
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid multipart body: %w", err)
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, "", errors.New(`multipart body has no "file" field`)
+			}
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid multipart body: %w", err)
+			}
+			if part.FormName() == "file" {
+				return part, part.FileName(), nil
+			}
+		}
+	default:
+		return nil, "", errors.New("Content-Type must be multipart/form-data or text/csv")
+	}
+}
+
+func (h *ImportHandler) getImport(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(rawID)
+	if err != nil {
+		h.sendError(w, "Invalid import id", http.StatusBadRequest)
+		return
+	}
+
+	imp, err := h.importService.GetImport(r.Context(), id)
+	if err != nil {
+		h.sendError(w, "Failed to get import: "+err.Error(), importStatusForError(err))
+		return
+	}
+
+	h.sendJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "ok",
+		Data:    imp,
+	})
+}
+
+// downloadErrors streams the rejected rows as CSV: the line in the uploaded
+// file, the reason and the row's original values
+func (h *ImportHandler) downloadErrors(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(rawID)
+	if err != nil {
+		h.sendError(w, "Invalid import id", http.StatusBadRequest)
+		return
+	}
+
+	imp, err := h.importService.GetImport(r.Context(), id)
+	if err != nil {
+		h.sendError(w, "Failed to get import: "+err.Error(), importStatusForError(err))
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, id.Hex()))
+
+	out := csv.NewWriter(w)
+	err = out.Write(append([]string{"line", "error"}, imp.Columns...))
+	if err == nil {
+		err = h.importService.EachRowError(r.Context(), id, func(row domain.ImportRowError) error {
+			return out.Write(append([]string{strconv.Itoa(row.Line), row.Error}, row.Values...))
+		})
+	}
+	if err == nil {
+		out.Flush()
+		err = out.Error()
+	}
+	if err != nil {
+		// The 200 is already out; aborting breaks the chunked transfer so the
+		// client cannot take a cut-short report for a complete one
+		h.logger.Error("Error report of import %s aborted: %v", id.Hex(), err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// importStatusForError maps unusable uploads to 400 and unknown imports to 404
+func importStatusForError(err error) int {
+	if errors.Is(err, repository.ErrImportNotFound) {
+		return http.StatusNotFound
+	}
+
+	for _, target := range []error{
+		domain.ErrInvalidCSV,
+		domain.ErrMissingColumn,
+		domain.ErrUnknownVariable,
+		domain.ErrContentAmbiguous,
+	} {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (h *ImportHandler) sendJSON(w http.ResponseWriter, statusCode int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *ImportHandler) sendError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Message: message,
+	})
+}
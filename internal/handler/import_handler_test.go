@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reportService streams rows to the error report and then fails with err
+type reportService struct {
+	service.ImportService
+
+	rows []domain.ImportRowError
+	err  error
+}
+
+func (s *reportService) GetImport(ctx context.Context, id primitive.ObjectID) (*domain.Import, error) {
+	return &domain.Import{ID: id, Columns: []string{"phone", "text"}}, nil
+}
+
+func (s *reportService) EachRowError(ctx context.Context, id primitive.ObjectID, fn func(domain.ImportRowError) error) error {
+	for _, row := range s.rows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestDownloadImportErrors(t *testing.T) {
+	rows := []domain.ImportRowError{{Line: 2, Error: "phone number is required", Values: []string{"", "hi"}}}
+	h := NewImportHandler(&reportService{rows: rows}, logger.New())
+
+	rec := httptest.NewRecorder()
+	path := "/api/messages/import/" + primitive.NewObjectID().Hex() + "/errors"
+	h.Import(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	want := "line,error,phone,text\n2,phone number is required,,hi\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), want)
+	}
+}
+
+func TestDownloadImportErrors_AbortsOnFailureMidStream(t *testing.T) {
+	rows := []domain.ImportRowError{{Line: 2, Error: "bad", Values: []string{"", "hi"}}}
+	h := NewImportHandler(&reportService{rows: rows, err: errors.New("cursor failed")}, logger.New())
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", r)
+		}
+	}()
+
+	path := "/api/messages/import/" + primitive.NewObjectID().Hex() + "/errors"
+	h.Import(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	t.Error("report finished normally after the cursor failed")
+}
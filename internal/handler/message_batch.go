@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+)
+
+// maxBatchLine bounds a single NDJSON line
+const maxBatchLine = 64 * 1024
+
+// maxBatchItem is the room allowed per message when bounding a batch body;
+// a message is at most a few hundred bytes of JSON
+const maxBatchItem = 4 * 1024
+
+var errBatchTooLarge = errors.New("batch too large")
+
+// BatchItemResult is the outcome of one message of a batch, in request order
+type BatchItemResult struct {
+	Index   int             `json:"index"`
+	Success bool            `json:"success"`
+	Message *domain.Message `json:"message,omitempty"`
+	// Replayed means the message was created earlier with the same key
+	Replayed bool   `json:"replayed,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CreateMessages creates many messages in one request. The body is either a
+// JSON array of CreateMessageRequest or NDJSON with one per line. Every item
+// is validated and created on its own; the response reports each by index
+// with 201 when all were created and 207 otherwise. An Idempotency-Key header
+// gives each item without an external_id the key "<header>:<index>", so the
+// whole batch can be sent again safely.
+func (h *MessageHandler) CreateMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batchKey := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+
+	limit := h.maxBatchBody()
+	requests, decodeErrs, err := h.decodeBatch(http.MaxBytesReader(w, r.Body, limit))
+	if errors.Is(err, errBatchTooLarge) {
+		h.sendError(w, fmt.Sprintf("A batch can contain at most %d messages", h.maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.sendError(w, fmt.Sprintf("A batch body can be at most %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requests) == 0 {
+		h.sendError(w, "Invalid request body: no messages", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]BatchItemResult, len(requests))
+	var messages []*domain.Message
+	var indexes []int
+
+	for i, req := range requests {
+		results[i].Index = i
+		if decodeErrs[i] != nil {
+			results[i].Error = decodeErrs[i].Error()
+			continue
+		}
+
+		if batchKey != "" && req.ExternalID == "" {
+			req.ExternalID = batchKey + ":" + strconv.Itoa(i)
+		}
+
+		message, err := req.toMessage()
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		messages = append(messages, message)
+		indexes = append(indexes, i)
+	}
+
+	created := h.messageService.CreateMessages(r.Context(), messages)
+	for i, index := range indexes {
+		if created[i].Err != nil {
+			results[index].Error = created[i].Err.Error()
+			continue
+		}
+		results[index].Success = true
+		results[index].Replayed = created[i].Replayed
+		results[index].Message = messages[i]
+	}
+
+	succeeded, replayed := 0, 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+		if result.Replayed {
+			replayed++
+		}
+	}
+
+	status, message := http.StatusCreated, "created"
+	if succeeded < len(results) {
+		status, message = http.StatusMultiStatus, fmt.Sprintf("created %d of %d", succeeded, len(results))
+	}
+
+	h.sendJSON(w, status, Response{
+		Success: succeeded == len(results),
+		Message: message,
+		Data: map[string]interface{}{
+			"total":    len(results),
+			"created":  succeeded - replayed,
+			"replayed": replayed,
+			"failed":   len(results) - succeeded,
+			"results":  results,
+		},
+	})
+}
+
+// maxBatchBody bounds the body of a batch by the number of messages it may
+// contain, leaving room for one line of the longest length
+func (h *MessageHandler) maxBatchBody() int64 {
+	return int64(h.maxBatchSize)*maxBatchItem + maxBatchLine
+}
+
+// decodeBatch reads a JSON array or NDJSON body. Items that are valid JSON
+// but do not fit CreateMessageRequest, and malformed NDJSON lines, get an
+// entry in the returned per-item errors. A malformed array fails as a whole
+// since the items after the error cannot be found.
+func (h *MessageHandler) decodeBatch(body io.Reader) ([]CreateMessageRequest, []error, error) {
+	reader := bufio.NewReader(body)
+
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if first == '[' {
+		return h.decodeArray(reader)
+	}
+	return h.decodeLines(reader)
+}
+
+func (h *MessageHandler) decodeArray(reader io.Reader) ([]CreateMessageRequest, []error, error) {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+
+	var requests []CreateMessageRequest
+	var errs []error
+	for decoder.More() {
+		if len(requests) == h.maxBatchSize {
+			return nil, nil, errBatchTooLarge
+		}
+
+		var req CreateMessageRequest
+		err := decoder.Decode(&req)
+
+		var typeErr *json.UnmarshalTypeError
+		if err != nil && !errors.As(err, &typeErr) {
+			return nil, nil, err
+		}
+		requests = append(requests, req)
+		errs = append(errs, err)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	return requests, errs, nil
+}
+
+func (h *MessageHandler) decodeLines(reader io.Reader) ([]CreateMessageRequest, []error, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 4096), maxBatchLine)
+
+	var requests []CreateMessageRequest
+	var errs []error
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(requests) == h.maxBatchSize {
+			return nil, nil, errBatchTooLarge
+		}
+
+		var req CreateMessageRequest
+		err := json.Unmarshal(line, &req)
+		requests = append(requests, req)
+		errs = append(errs, err)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return requests, errs, nil
+}
+
+// peekNonSpace returns the first byte after any leading whitespace without
+// consuming it
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, reader.UnreadByte()
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+// batchService creates every message except those whose content is in reject
+type batchService struct {
+	service.MessageService
+
+	reject map[string]bool
+	calls  int
+}
+
+func (s *batchService) CreateMessages(ctx context.Context, messages []*domain.Message) []service.CreateResult {
+	s.calls++
+	results := make([]service.CreateResult, len(messages))
+	for i, m := range messages {
+		if s.reject[m.Content] {
+			results[i].Err = errors.New("duplicate key")
+		}
+	}
+	return results
+}
+
+// batchResponse is the body of a batch response
+type batchResponse struct {
+	Data struct {
+		Total   int               `json:"total"`
+		Created int               `json:"created"`
+		Failed  int               `json:"failed"`
+		Results []BatchItemResult `json:"results"`
+	} `json:"data"`
+}
+
+func postBatch(h *MessageHandler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.CreateMessages(rec, httptest.NewRequest(http.MethodPost, "/api/messages/batch", strings.NewReader(body)))
+	return rec
+}
+
+func TestCreateMessages_PartialResult(t *testing.T) {
+	h := NewMessageHandler(&batchService{reject: map[string]bool{"taken": true}}, 10, logger.New())
+
+	for _, body := range []string{
+		`[{"phone_number":"+905551111111","content":"hi"},{"phone_number":42,"content":"hi"},{"phone_number":"+905552222222","content":"taken"}]`,
+		"{\"phone_number\":\"+905551111111\",\"content\":\"hi\"}\n{\"phone_number\":42,\"content\":\"hi\"}\n{\"phone_number\":\"+905552222222\",\"content\":\"taken\"}\n",
+	} {
+		rec := postBatch(h, body)
+		if rec.Code != http.StatusMultiStatus {
+			t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body.String())
+		}
+
+		var resp batchResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Data.Total != 3 || resp.Data.Created != 1 || resp.Data.Failed != 2 {
+			t.Errorf("counts = %+v, want 1 of 3 created", resp.Data)
+		}
+		for i, wantSuccess := range []bool{true, false, false} {
+			result := resp.Data.Results[i]
+			if result.Index != i || result.Success != wantSuccess || (result.Error == "") == !wantSuccess {
+				t.Errorf("result %d = %+v", i, result)
+			}
+		}
+	}
+}
+
+func TestCreateMessages_AllCreated(t *testing.T) {
+	h := NewMessageHandler(&batchService{}, 10, logger.New())
+
+	rec := postBatch(h, `[{"phone_number":"+905551111111","content":"hi"}]`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status = %d, want 201", rec.Code)
+	}
+}
+
+func TestCreateMessages_TooLarge(t *testing.T) {
+	item := `{"phone_number":"+905551111111","content":"hi"}`
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "too many messages", body: "[" + strings.Repeat(item+",", 2) + item + "]"},
+		{name: "body over the size cap", body: fmt.Sprintf(`[{"phone_number":"+905551111111","content":"%s"}]`, strings.Repeat("a", 100000))},
+	}
+
+	for _, tt := range tests {
+		svc := &batchService{}
+		h := NewMessageHandler(svc, 2, logger.New())
+
+		rec := postBatch(h, tt.body)
+		if rec.Code != http.StatusRequestEntityTooLarge || svc.calls != 0 {
+			t.Errorf("%s: status = %d, want 413 without creating anything", tt.name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportService streams messages to the export and then fails with err
+type exportService struct {
+	service.MessageService
+
+	messages []*domain.Message
+	err      error
+}
+
+func (s *exportService) ExportMessages(ctx context.Context, query repository.MessageQuery, fn func(*domain.Message) error) error {
+	for _, m := range s.messages {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func exportMessages(n int) []*domain.Message {
+	messages := make([]*domain.Message, n)
+	for i := range messages {
+		messages[i] = &domain.Message{ID: primitive.NewObjectID(), PhoneNumber: "+905551111111", Content: "hi", Status: domain.StatusSent}
+	}
+	return messages
+}
+
+func TestExportMessages_Formats(t *testing.T) {
+	h := NewMessageHandler(&exportService{messages: exportMessages(2)}, 10, logger.New())
+
+	tests := []struct {
+		query       string
+		contentType string
+		extension   string
+		lines       int
+	}{
+		{query: "", contentType: "text/csv", extension: ".csv", lines: 3},
+		{query: "?format=ndjson", contentType: "application/x-ndjson", extension: ".ndjson", lines: 2},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ExportMessages(rec, httptest.NewRequest(http.MethodGet, "/api/messages/export"+tt.query, nil))
+
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%q: %d %s, want 200 %s", tt.query, rec.Code, rec.Header().Get("Content-Type"), tt.contentType)
+		}
+		disposition := rec.Header().Get("Content-Disposition")
+		if !strings.HasPrefix(disposition, "attachment;") || !strings.HasSuffix(disposition, tt.extension+`"`) {
+			t.Errorf("%q: Content-Disposition = %s", tt.query, disposition)
+		}
+		if lines := strings.Count(rec.Body.String(), "\n"); lines != tt.lines {
+			t.Errorf("%q: %d lines, want %d", tt.query, lines, tt.lines)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ExportMessages(rec, httptest.NewRequest(http.MethodGet, "/api/messages/export?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestExportMessages_FailureBeforeFirstRow(t *testing.T) {
+	h := NewMessageHandler(&exportService{err: errors.New("cursor failed")}, 10, logger.New())
+
+	rec := httptest.NewRecorder()
+	h.ExportMessages(rec, httptest.NewRequest(http.MethodGet, "/api/messages/export", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Disposition") != "" {
+		t.Errorf("status = %d, want a plain 500", rec.Code)
+	}
+}
+
+func TestExportMessages_AbortsOnFailureMidStream(t *testing.T) {
+	h := NewMessageHandler(&exportService{messages: exportMessages(3), err: errors.New("cursor failed")}, 10, logger.New())
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", r)
+		}
+	}()
+
+	h.ExportMessages(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/messages/export", nil))
+	t.Error("export finished normally after the cursor failed")
+}
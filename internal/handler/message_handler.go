@@ -2,35 +2,417 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
 )
 
 type MessageHandler struct {
 	messageService service.MessageService
+	maxBatchSize   int
+	logger         *logger.Logger
 }
 
-func NewMessageHandler(messageService service.MessageService) *MessageHandler {
+func NewMessageHandler(messageService service.MessageService, maxBatchSize int, logger *logger.Logger) *MessageHandler {
 	return &MessageHandler{
 		messageService: messageService,
+		maxBatchSize:   maxBatchSize,
+		logger:         logger,
 	}
 }
 
 type CreateMessageRequest struct {
-	PhoneNumber string `json:"phone_number"`
-	Content     string `json:"content"`
+	PhoneNumber string                 `json:"phone_number"`
+	Content     string                 `json:"content"`
+	SendAt      *time.Time             `json:"send_at,omitempty"`
+	Priority    domain.MessagePriority `json:"priority,omitempty"`
+	ExpiresAt   *time.Time             `json:"expires_at,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	// ExternalID is an idempotency key, alternatively sent as Idempotency-Key
+	ExternalID string `json:"external_id,omitempty"`
+	// TTL is an alternative to ExpiresAt, counted from send_at or from now
+	TTL string `json:"ttl,omitempty"`
+}
+
+var (
+	errExpiryConflict = errors.New("expires_at and ttl are mutually exclusive")
+	errKeyConflict    = errors.New("Idempotency-Key and external_id differ")
+)
+
+// idempotencyHeader carries the idempotency key of a create request
+const idempotencyHeader = "Idempotency-Key"
+
+// replayedHeader marks a response that returns a message created earlier
+const replayedHeader = "Idempotent-Replayed"
+
+// withKey applies an Idempotency-Key header, which must agree with
+// external_id when both are given
+func (req *CreateMessageRequest) withKey(key string) error {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil
+	}
+	if req.ExternalID != "" && req.ExternalID != key {
+		return errKeyConflict
+	}
+	req.ExternalID = key
+	return nil
 }
 
+func (req *CreateMessageRequest) toMessage() (*domain.Message, error) {
+	message := &domain.Message{
+		PhoneNumber: req.PhoneNumber,
+		Content:     req.Content,
+		SendAt:      req.SendAt,
+		Priority:    req.Priority,
+		ExpiresAt:   req.ExpiresAt,
+		Tags:        req.Tags,
+		ExternalID:  req.ExternalID,
+	}
+
+	if req.TTL != "" {
+		if req.ExpiresAt != nil {
+			return nil, errExpiryConflict
+		}
+
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %w", err)
+		}
+
+		start := time.Now()
+		if req.SendAt != nil {
+			start = *req.SendAt
+		}
+		expiresAt := start.Add(ttl)
+		message.ExpiresAt = &expiresAt
+	}
+
+	return message, nil
+}
+
+// GetSentMessages lists sent messages a page at a time. It takes the same
+// query parameters as GET /api/messages.
 func (h *MessageHandler) GetSentMessages(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	messages, err := h.messageService.GetSentMessages(r.Context())
+	query, err := parseMessageQuery(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.messageService.GetSentMessages(r.Context(), query)
+	if err != nil {
+		h.sendError(w, "Failed to get sent messages: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendPage(w, page)
+}
+
+// Messages serves the message collection:
+//
+//	GET  /api/messages
+//	POST /api/messages
+func (h *MessageHandler) Messages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listMessages(w, r)
+	case http.MethodPost:
+		h.CreateMessage(w, r)
+	default:
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *MessageHandler) listMessages(w http.ResponseWriter, r *http.Request) {
+	query, err := parseMessageQuery(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.messageService.ListMessages(r.Context(), query)
+	if err != nil {
+		h.sendError(w, "Failed to list messages: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendPage(w, page)
+}
+
+func (h *MessageHandler) CreateMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.withKey(r.Header.Get(idempotencyHeader)); err != nil {
+		h.sendError(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message, err := req.toMessage()
+	if err != nil {
+		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	replayed, err := h.messageService.CreateMessage(r.Context(), message)
+	if err != nil {
+		h.sendError(w, "Failed to create message: "+err.Error(), statusForError(err))
+		return
+	}
+
+	if replayed {
+		w.Header().Set(replayedHeader, "true")
+		h.sendResponse(w, Response{
+			Success: true,
+			Message: "already created",
+			Data:    message,
+		})
+		return
+	}
+
+	h.sendJSON(w, http.StatusCreated, Response{
+		Success: true,
+		Message: "created",
+		Data:    message,
+	})
+}
+
+func (h *MessageHandler) GetMessageStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts, err := h.messageService.GetMessageStats(r.Context())
 	if err != nil {
-		h.sendError(w, "Failed to get sent messages: "+err.Error(), http.StatusInternalServerError)
+		h.sendError(w, "Failed to get message stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"by_status": counts,
+			"expired":   counts[domain.StatusExpired],
+			"total":     total,
+		},
+	})
+}
+
+// DeadLetter serves the dead-letter queue:
+//
+//	GET  /api/messages/dead-letter
+//	GET  /api/messages/dead-letter/{id}
+//	POST /api/messages/dead-letter/{id}/requeue
+func (h *MessageHandler) DeadLetter(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/messages/dead-letter"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "":
+		h.listDeadLetters(w, r)
+	case len(parts) == 1:
+		h.getDeadLetter(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "requeue":
+		h.requeueDeadLetter(w, r, parts[0])
+	default:
+		h.sendError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Message serves a single message:
+//
+//	GET  /api/messages/{id}
+//	GET  /api/messages/{id}/events
+//	POST /api/messages/{id}/cancel
+//	POST /api/messages/{id}/retry
+func (h *MessageHandler) Message(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/messages"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path != "" && len(parts) == 1:
+		h.getMessage(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "events":
+		h.getMessageEvents(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "cancel":
+		h.cancelMessage(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "retry":
+		h.retryMessage(w, r, parts[0])
+	default:
+		h.sendError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func (h *MessageHandler) getMessage(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := h.parseID(w, rawID)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageService.GetMessage(r.Context(), id)
+	if err != nil {
+		h.sendError(w, "Failed to get message: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data:    message,
+	})
+}
+
+func (h *MessageHandler) getMessageEvents(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := h.parseID(w, rawID)
+	if !ok {
+		return
+	}
+
+	events, err := h.messageService.GetMessageEvents(r.Context(), id)
+	if err != nil {
+		h.sendError(w, "Failed to get message events: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"events": events,
+			"count":  len(events),
+		},
+	})
+}
+
+func (h *MessageHandler) cancelMessage(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := h.parseID(w, rawID)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageService.CancelMessage(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrStatusMismatch) {
+			h.sendError(w, "Only pending messages can be cancelled", http.StatusConflict)
+			return
+		}
+		h.sendError(w, "Failed to cancel message: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "cancelled",
+		Data:    message,
+	})
+}
+
+// RetryMessageRequest optionally corrects the recipient of a retried message
+type RetryMessageRequest struct {
+	PhoneNumber string `json:"phone_number,omitempty"`
+}
+
+func (h *MessageHandler) retryMessage(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := h.parseID(w, rawID)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req RetryMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.messageService.RetryMessage(r.Context(), id, strings.TrimSpace(req.PhoneNumber))
+	if err != nil {
+		if errors.Is(err, repository.ErrStatusMismatch) {
+			h.sendError(w, "Only failed or dead-lettered messages can be retried", http.StatusConflict)
+			return
+		}
+		h.sendError(w, "Failed to retry message: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "requeued",
+		Data:    message,
+	})
+}
+
+func (h *MessageHandler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := h.messageService.GetDeadLetterMessages(r.Context(), limit)
+	if err != nil {
+		h.sendError(w, "Failed to get dead-lettered messages: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -44,32 +426,138 @@ func (h *MessageHandler) GetSentMessages(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (h *MessageHandler) CreateMessage(w http.ResponseWriter, r *http.Request) {
+func (h *MessageHandler) getDeadLetter(w http.ResponseWriter, r *http.Request, rawID string) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := h.parseID(w, rawID)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageService.GetDeadLetterMessage(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrStatusMismatch) {
+			h.sendError(w, "Message is not dead-lettered", http.StatusNotFound)
+			return
+		}
+		h.sendError(w, "Failed to get message: "+err.Error(), statusForError(err))
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data:    message,
+	})
+}
+
+func (h *MessageHandler) requeueDeadLetter(w http.ResponseWriter, r *http.Request, rawID string) {
 	if r.Method != http.MethodPost {
 		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req CreateMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+	id, ok := h.parseID(w, rawID)
+	if !ok {
 		return
 	}
 
-	if err := h.messageService.CreateMessage(r.Context(), req.PhoneNumber, req.Content); err != nil {
-		h.sendError(w, "Failed to create message: "+err.Error(), http.StatusInternalServerError)
+	if err := h.messageService.RequeueDeadLetter(r.Context(), id); err != nil {
+		h.sendError(w, "Failed to requeue message: "+err.Error(), statusForError(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
 	h.sendResponse(w, Response{
 		Success: true,
-		Message: "created",
+		Message: "requeued",
+	})
+}
+
+func (h *MessageHandler) parseID(w http.ResponseWriter, rawID string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(rawID)
+	if err != nil {
+		h.sendError(w, "Invalid message id", http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultListLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxListLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+	}
+	return limit, nil
+}
+
+// statusForError maps domain validation errors to 400, lookups to 404,
+// status conflicts to 409 and everything else to 500
+func statusForError(err error) int {
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return http.StatusNotFound
+	}
+
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+
+	if errors.Is(err, repository.ErrStatusMismatch) || errors.Is(err, domain.ErrIllegalTransition) ||
+		errors.Is(err, domain.ErrIdempotencyConflict) || errors.Is(err, repository.ErrAmbiguousProviderID) {
+		return http.StatusConflict
+	}
+
+	validationErrors := []error{
+		domain.ErrEmptyContent,
+		domain.ErrMessageTooLong,
+		domain.ErrInvalidPhoneNumber,
+		domain.ErrSendAtTooEarly,
+		domain.ErrSendAtTooLate,
+		domain.ErrInvalidPriority,
+		domain.ErrAlreadyExpired,
+		domain.ErrExpiresBeforeSend,
+		domain.ErrTooManyTags,
+		domain.ErrInvalidTag,
+		domain.ErrInvalidExternalID,
+		domain.ErrInvalidReceiptStatus,
+		domain.ErrMissingReceiptID,
+	}
+
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (h *MessageHandler) sendPage(w http.ResponseWriter, page *repository.MessagePage) {
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"messages":    page.Messages,
+			"count":       len(page.Messages),
+			"next_cursor": page.NextCursor,
+		},
 	})
 }
 
 func (h *MessageHandler) sendResponse(w http.ResponseWriter, response Response) {
+	h.sendJSON(w, http.StatusOK, response)
+}
+
+func (h *MessageHandler) sendJSON(w http.ResponseWriter, statusCode int, response Response) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+)
+
+// parseMessageQuery reads the message listing parameters: the filters of
+// parseMessageFilter, and limit and cursor for the page size and the
+// next_cursor of the previous page
+func parseMessageQuery(r *http.Request) (repository.MessageQuery, error) {
+	query, err := parseMessageFilter(r)
+	if err != nil {
+		return query, err
+	}
+
+	query.Cursor = r.URL.Query().Get("cursor")
+	if query.Limit, err = parseLimit(r); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// parseMessageFilter reads the parameters that select and order messages:
+//
+//	status                  comma-separated statuses
+//	phone, provider         exact matches
+//	tag                     repeatable or comma-separated, all must match
+//	created_from/created_to RFC 3339, from inclusive, to exclusive
+//	sent_from/sent_to       RFC 3339, from inclusive, to exclusive
+//	sort                    created_at or sent_at
+//	order                   asc or desc (default)
+func parseMessageFilter(r *http.Request) (repository.MessageQuery, error) {
+	values := r.URL.Query()
+
+	query := repository.MessageQuery{
+		PhoneNumber: values.Get("phone"),
+		Provider:    values.Get("provider"),
+		Tags:        splitList(values["tag"]),
+		SortBy:      repository.SortField(values.Get("sort")),
+	}
+
+	for _, raw := range splitList(values["status"]) {
+		status := domain.MessageStatus(raw)
+		if !status.IsValid() {
+			return query, fmt.Errorf("unknown status %q", raw)
+		}
+		query.Statuses = append(query.Statuses, status)
+	}
+
+	if query.SortBy != "" && !query.SortBy.IsValid() {
+		return query, fmt.Errorf("sort must be %s or %s", repository.SortByCreatedAt, repository.SortBySentAt)
+	}
+
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if query.CreatedFrom, err = parseTime(values, "created_from"); err != nil {
+		return query, err
+	}
+	if query.CreatedTo, err = parseTime(values, "created_to"); err != nil {
+		return query, err
+	}
+	if query.SentFrom, err = parseTime(values, "sent_from"); err != nil {
+		return query, err
+	}
+	if query.SentTo, err = parseTime(values, "sent_to"); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// splitList flattens repeated and comma-separated values, dropping empty ones
+func splitList(raw []string) []string {
+	var list []string
+	for _, value := range raw {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func parseTime(values url.Values, key string) (*time.Time, error) {
+	value := values.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time", key)
+	}
+	return &t, nil
+}
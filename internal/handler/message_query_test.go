@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+// listService records the query it was asked for and fails with err
+type listService struct {
+	service.MessageService
+
+	query *repository.MessageQuery
+	err   error
+}
+
+func (s *listService) ListMessages(ctx context.Context, query repository.MessageQuery) (*repository.MessagePage, error) {
+	s.query = &query
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &repository.MessagePage{}, nil
+}
+
+func TestListMessages_Filters(t *testing.T) {
+	svc := &listService{}
+	h := NewMessageHandler(svc, 10, logger.New())
+
+	rec := httptest.NewRecorder()
+	url := "/api/messages?status=sent,delivered&tag=promo&tag=spring&sent_from=2024-05-01T00:00:00Z&sort=sent_at&order=asc&limit=20&cursor=abc"
+	h.Messages(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	q := svc.query
+	if len(q.Statuses) != 2 || q.Statuses[1] != domain.StatusDelivered || len(q.Tags) != 2 {
+		t.Errorf("query = %+v, want both statuses and tags", q)
+	}
+	if q.SentFrom == nil || q.SortBy != repository.SortBySentAt || !q.Ascending || q.Limit != 20 || q.Cursor != "abc" {
+		t.Errorf("query = %+v", q)
+	}
+}
+
+func TestListMessages_InvalidParameters(t *testing.T) {
+	for _, params := range []string{
+		"status=sending",
+		"sort=phone",
+		"order=up",
+		"created_from=yesterday",
+		"limit=0",
+		"limit=1000",
+	} {
+		svc := &listService{}
+		h := NewMessageHandler(svc, 10, logger.New())
+
+		rec := httptest.NewRecorder()
+		h.Messages(rec, httptest.NewRequest(http.MethodGet, "/api/messages?"+params, nil))
+
+		if rec.Code != http.StatusBadRequest || svc.query != nil {
+			t.Errorf("%s: status = %d, want 400 without a query", params, rec.Code)
+		}
+	}
+}
+
+func TestListMessages_InvalidCursor(t *testing.T) {
+	h := NewMessageHandler(&listService{err: repository.ErrInvalidCursor}, 10, logger.New())
+
+	rec := httptest.NewRecorder()
+	h.Messages(rec, httptest.NewRequest(http.MethodGet, "/api/messages?cursor=garbage", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
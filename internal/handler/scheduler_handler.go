@@ -2,18 +2,25 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/scheduler"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
 )
 
 type SchedulerHandler struct {
 	scheduler *scheduler.Scheduler
+	router    *service.Router
 }
 
-func NewSchedulerHandler(scheduler *scheduler.Scheduler) *SchedulerHandler {
+func NewSchedulerHandler(scheduler *scheduler.Scheduler, router *service.Router) *SchedulerHandler {
 	return &SchedulerHandler{
 		scheduler: scheduler,
+		router:    router,
 	}
 }
 
@@ -30,6 +37,15 @@ func (h *SchedulerHandler) Start(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if h.scheduler.IsRunning() {
+		if h.scheduler.PausedReason() != "" {
+			h.scheduler.Resume()
+			h.sendResponse(w, Response{
+				Success: true,
+				Message: "resumed",
+			})
+			return
+		}
+
 		h.sendResponse(w, Response{
 			Success: true,
 			Message: "Scheduler is already running",
@@ -80,24 +96,181 @@ func (h *SchedulerHandler) Status(w http.ResponseWriter, r *http.Request) {
 	}
 
 	isRunning := h.scheduler.IsRunning()
+	pausedReason := h.scheduler.PausedReason()
 	status := "stopped"
 	if isRunning {
 		status = "running"
+		if pausedReason != "" {
+			status = "paused"
+		}
+	}
+
+	// Redis being down must not hide the rest of the status
+	leader, err := h.scheduler.LeaderStatus(r.Context())
+	if err != nil {
+		leader.Error = "Failed to get leader status: " + err.Error()
+	}
+
+	data := map[string]interface{}{
+		"status":        status,
+		"running":       isRunning,
+		"paused_reason": pausedReason,
+		"schedule":      h.scheduler.Describe(),
+		"next_runs":     h.scheduler.NextRuns(5),
+		"leader":        leader,
+		"providers":     h.router.Status(),
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "ok",
+		Data:    data,
+	})
+}
+
+func (h *SchedulerHandler) Trigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.scheduler.Trigger(); err != nil {
+		if errors.Is(err, scheduler.ErrBatchInProgress) || errors.Is(err, scheduler.ErrPaused) ||
+			errors.Is(err, scheduler.ErrNotLeader) || errors.Is(err, scheduler.ErrStopping) {
+			h.sendError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.sendError(w, "Failed to trigger batch: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	h.sendJSON(w, http.StatusAccepted, Response{
+		Success: true,
+		Message: "triggered",
+	})
+}
+
+func (h *SchedulerHandler) Runs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			h.sendError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	runs := h.scheduler.Runs(limit)
 	h.sendResponse(w, Response{
 		Success: true,
 		Message: "ok",
 		Data: map[string]interface{}{
-			"status":  status,
-			"running": isRunning,
+			"runs":  runs,
+			"count": len(runs),
 		},
 	})
 }
 
+// UpdateSchedulerConfigRequest changes only the fields that are present
+type UpdateSchedulerConfigRequest struct {
+	Interval     *string `json:"interval"`
+	BatchSize    *int    `json:"batch_size"`
+	BatchTimeout *string `json:"batch_timeout"`
+}
+
+type SchedulerConfigResponse struct {
+	Interval     string `json:"interval"`
+	BatchSize    int    `json:"batch_size"`
+	BatchTimeout string `json:"batch_timeout"`
+}
+
+func (h *SchedulerHandler) Config(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.sendResponse(w, Response{
+			Success: true,
+			Message: "ok",
+			Data:    newSchedulerConfigResponse(h.scheduler.Settings()),
+		})
+	case http.MethodPut:
+		h.updateConfig(w, r)
+	default:
+		h.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SchedulerHandler) updateConfig(w http.ResponseWriter, r *http.Request) {
+	var req UpdateSchedulerConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings := h.scheduler.Settings()
+	if req.Interval != nil && h.scheduler.HasSchedule() {
+		h.sendError(w, "Invalid scheduler config: interval does not apply while batches follow "+h.scheduler.Describe(),
+			http.StatusBadRequest)
+		return
+	}
+	if req.Interval != nil {
+		interval, err := time.ParseDuration(*req.Interval)
+		if err != nil {
+			h.sendError(w, "Invalid interval: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings.Interval = interval
+	}
+	if req.BatchSize != nil {
+		settings.BatchSize = *req.BatchSize
+	}
+	if req.BatchTimeout != nil {
+		timeout, err := time.ParseDuration(*req.BatchTimeout)
+		if err != nil {
+			h.sendError(w, "Invalid batch timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings.BatchTimeout = timeout
+	}
+
+	if err := h.scheduler.ValidateSettings(settings); err != nil {
+		h.sendError(w, "Invalid scheduler config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.scheduler.UpdateSettings(r.Context(), settings)
+	if err != nil {
+		h.sendError(w, "Failed to update scheduler config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.sendResponse(w, Response{
+		Success: true,
+		Message: "updated",
+		Data:    newSchedulerConfigResponse(*updated),
+	})
+}
+
+func newSchedulerConfigResponse(settings domain.SchedulerSettings) SchedulerConfigResponse {
+	return SchedulerConfigResponse{
+		Interval:     settings.Interval.String(),
+		BatchSize:    settings.BatchSize,
+		BatchTimeout: settings.BatchTimeout.String(),
+	}
+}
+
 func (h *SchedulerHandler) sendResponse(w http.ResponseWriter, response Response) {
+	h.sendJSON(w, http.StatusOK, response)
+}
+
+func (h *SchedulerHandler) sendJSON(w http.ResponseWriter, statusCode int, response Response) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
 
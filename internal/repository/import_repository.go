@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// importReportTTL is how long imports and their error reports are kept
+const importReportTTL = 7 * 24 * time.Hour
+
+var ErrImportNotFound = errors.New("import not found")
+
+type ImportRepository interface {
+	CreateImport(ctx context.Context, imp *domain.Import) error
+	FinishImport(ctx context.Context, imp *domain.Import) error
+	GetImport(ctx context.Context, id primitive.ObjectID) (*domain.Import, error)
+	AddRowErrors(ctx context.Context, rows []domain.ImportRowError) error
+	// EachRowError calls fn for every rejected row of an import in file order
+	EachRowError(ctx context.Context, id primitive.ObjectID, fn func(domain.ImportRowError) error) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type importRepository struct {
+	imports *mongo.Collection
+	errors  *mongo.Collection
+}
+
+func NewImportRepository(db *mongo.Database) ImportRepository {
+	return &importRepository{
+		imports: db.Collection("imports"),
+		errors:  db.Collection("import_errors"),
+	}
+}
+
+func (r *importRepository) CreateImport(ctx context.Context, imp *domain.Import) error {
+	imp.ID = primitive.NewObjectID()
+	imp.StartedAt = time.Now()
+	imp.Status = domain.ImportRunning
+
+	if _, err := r.imports.InsertOne(ctx, imp); err != nil {
+		return fmt.Errorf("failed to create import: %w", err)
+	}
+
+	return nil
+}
+
+// FinishImport stores the final counts and status of an import
+func (r *importRepository) FinishImport(ctx context.Context, imp *domain.Import) error {
+	now := time.Now()
+	imp.FinishedAt = &now
+
+	update := bson.M{"$set": bson.M{
+		"status":      imp.Status,
+		"rows":        imp.Rows,
+		"accepted":    imp.Accepted,
+		"suppressed":  imp.Suppressed,
+		"replayed":    imp.Replayed,
+		"rejected":    imp.Rejected,
+		"error":       imp.Error,
+		"finished_at": imp.FinishedAt,
+	}}
+
+	if _, err := r.imports.UpdateByID(ctx, imp.ID, update); err != nil {
+		return fmt.Errorf("failed to finish import: %w", err)
+	}
+
+	return nil
+}
+
+func (r *importRepository) GetImport(ctx context.Context, id primitive.ObjectID) (*domain.Import, error) {
+	var imp domain.Import
+	err := r.imports.FindOne(ctx, bson.M{"_id": id}).Decode(&imp)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get import: %w", err)
+	}
+
+	return &imp, nil
+}
+
+func (r *importRepository) AddRowErrors(ctx context.Context, rows []domain.ImportRowError) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// created_at only drives the TTL index
+	now := time.Now()
+	docs := make([]interface{}, len(rows))
+	for i, row := range rows {
+		docs[i] = bson.M{
+			"import_id":  row.ImportID,
+			"line":       row.Line,
+			"values":     row.Values,
+			"error":      row.Error,
+			"created_at": now,
+		}
+	}
+
+	if _, err := r.errors.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to store import errors: %w", err)
+	}
+
+	return nil
+}
+
+func (r *importRepository) EachRowError(ctx context.Context, id primitive.ObjectID, fn func(domain.ImportRowError) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "line", Value: 1}})
+
+	cursor, err := r.errors.Find(ctx, bson.M{"import_id": id}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to query import errors: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row domain.ImportRowError
+		if err := cursor.Decode(&row); err != nil {
+			return fmt.Errorf("failed to decode import error: %w", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read import errors: %w", err)
+	}
+	return nil
+}
+
+// EnsureIndexes creates the report lookup index and expires old imports
+func (r *importRepository) EnsureIndexes(ctx context.Context) error {
+	ttl := int32(importReportTTL.Seconds())
+
+	_, err := r.errors.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "import_id", Value: 1}, {Key: "line", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(ttl)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create import error indexes: %w", err)
+	}
+
+	_, err = r.imports.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "started_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(ttl),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create import indexes: %w", err)
+	}
+
+	return nil
+}
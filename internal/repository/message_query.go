@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField is a field messages can be listed by
+type SortField string
+
+const (
+	SortByCreatedAt SortField = "created_at"
+	SortBySentAt    SortField = "sent_at"
+)
+
+func (f SortField) IsValid() bool {
+	return f == SortByCreatedAt || f == SortBySentAt
+}
+
+// MessageQuery filters and pages through messages. Zero-valued filters match
+// everything; Tags must all be present on a message.
+type MessageQuery struct {
+	Statuses    []domain.MessageStatus
+	PhoneNumber string
+	Provider    string
+	Tags        []string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	SentFrom    *time.Time
+	SentTo      *time.Time
+
+	SortBy    SortField
+	Ascending bool
+	Limit     int
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+}
+
+// MessagePage is one page of a message listing
+type MessagePage struct {
+	Messages []*domain.Message `json:"messages"`
+	// NextCursor fetches the following page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// pageCursor is the position after the last message of a page. It pairs the
+// sort value with the id so messages sharing a timestamp are neither skipped
+// nor repeated.
+type pageCursor struct {
+	Value time.Time          `json:"v"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ListMessages returns one page of messages matching query, ordered by
+// query.SortBy and then by id
+func (r *messageRepository) ListMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortByCreatedAt
+	}
+	if !query.SortBy.IsValid() {
+		return nil, fmt.Errorf("cannot sort by %q", query.SortBy)
+	}
+
+	filter := query.filter()
+	field := string(query.SortBy)
+
+	cmp := "$lt"
+	if query.Ascending {
+		cmp = "$gt"
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{field: bson.M{cmp: cursor.Value}},
+			bson.M{field: cursor.Value, "_id": bson.M{cmp: cursor.ID}},
+		}})
+	}
+
+	// One extra document tells whether there is another page
+	opts := options.Find().
+		SetSort(query.sort()).
+		SetLimit(int64(query.Limit) + 1).
+		SetProjection(withoutEvents)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	messages := []*domain.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	page := &MessagePage{Messages: messages}
+	if len(messages) > query.Limit {
+		page.Messages = messages[:query.Limit]
+		last := page.Messages[query.Limit-1]
+
+		next := pageCursor{Value: last.CreatedAt, ID: last.ID}
+		if query.SortBy == SortBySentAt && last.SentAt != nil {
+			next.Value = *last.SentAt
+		}
+		page.NextCursor = encodeCursor(next)
+	}
+
+	return page, nil
+}
+
+// exportBatchSize is the number of messages fetched per round trip while
+// exporting
+const exportBatchSize = 1000
+
+// ExportMessages calls fn for every message matching query, in query order,
+// reading them from the cursor as fn consumes them. Limit and Cursor are
+// ignored. It stops at the first error fn returns.
+func (r *messageRepository) ExportMessages(ctx context.Context, query MessageQuery, fn func(*domain.Message) error) error {
+	if query.SortBy == "" {
+		query.SortBy = SortByCreatedAt
+	}
+	if !query.SortBy.IsValid() {
+		return fmt.Errorf("cannot sort by %q", query.SortBy)
+	}
+
+	opts := options.Find().
+		SetSort(query.sort()).
+		SetBatchSize(exportBatchSize).
+		SetProjection(withoutEvents)
+
+	cursor, err := r.collection.Find(ctx, query.filter(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message domain.Message
+		if err := cursor.Decode(&message); err != nil {
+			return fmt.Errorf("failed to decode message: %w", err)
+		}
+		if err := fn(&message); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+	return nil
+}
+
+// sort orders by the sort field, breaking ties by id
+func (q MessageQuery) sort() bson.D {
+	order := -1
+	if q.Ascending {
+		order = 1
+	}
+	return bson.D{{Key: string(q.SortBy), Value: order}, {Key: "_id", Value: order}}
+}
+
+func (q MessageQuery) filter() bson.D {
+	filter := bson.D{}
+
+	if len(q.Statuses) > 0 {
+		filter = append(filter, bson.E{Key: "status", Value: bson.M{"$in": q.Statuses}})
+	}
+	if q.PhoneNumber != "" {
+		filter = append(filter, bson.E{Key: "phone_number", Value: q.PhoneNumber})
+	}
+	if q.Provider != "" {
+		filter = append(filter, bson.E{Key: "provider", Value: q.Provider})
+	}
+	if len(q.Tags) > 0 {
+		filter = append(filter, bson.E{Key: "tags", Value: bson.M{"$all": q.Tags}})
+	}
+	if r := timeRange(q.CreatedFrom, q.CreatedTo); r != nil {
+		filter = append(filter, bson.E{Key: "created_at", Value: r})
+	}
+
+	// Messages never sent have no sent_at and cannot be ordered by it
+	sent := timeRange(q.SentFrom, q.SentTo)
+	if sent == nil && q.SortBy == SortBySentAt {
+		sent = bson.M{"$ne": nil}
+	}
+	if sent != nil {
+		filter = append(filter, bson.E{Key: "sent_at", Value: sent})
+	}
+
+	return filter
+}
+
+// timeRange matches from inclusive to to exclusive, or returns nil for no bounds
+func timeRange(from, to *time.Time) bson.M {
+	if from == nil && to == nil {
+		return nil
+	}
+
+	r := bson.M{}
+	if from != nil {
+		r["$gte"] = *from
+	}
+	if to != nil {
+		r["$lt"] = *to
+	}
+	return r
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,13 +13,46 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrStatusMismatch  = errors.New("message is not in the expected status")
+	// ErrDuplicateExternalID means another message already has the external id
+	ErrDuplicateExternalID = errors.New("external id already exists")
+	// ErrAmbiguousProviderID means messages of several providers have the
+	// provider message id, so a receipt must name its provider
+	ErrAmbiguousProviderID = errors.New("provider message id is used by more than one provider")
+)
+
+// duplicateKeyCode is the server error code of a unique index violation
+const duplicateKeyCode = 11000
+
 type MessageRepository interface {
 	GetPendingMessages(ctx context.Context, limit int) ([]*domain.Message, error)
-	GetSentMessages(ctx context.Context) ([]*domain.Message, error)
-	UpdateMessageStatus(ctx context.Context, message *domain.Message) error
+	ClaimPendingMessages(ctx context.Context, opts ClaimOptions) ([]*domain.Message, error)
+	ReleaseClaims(ctx context.Context, owner string, ids []primitive.ObjectID) error
+	ListMessages(ctx context.Context, query MessageQuery) (*MessagePage, error)
+	ExportMessages(ctx context.Context, query MessageQuery, fn func(*domain.Message) error) error
+	UpdateMessageStatus(ctx context.Context, message *domain.Message, event domain.StatusEvent) error
 	CreateMessage(ctx context.Context, message *domain.Message) error
+	CreateMessages(ctx context.Context, messages []*domain.Message) error
+	CountByStatus(ctx context.Context) (map[domain.MessageStatus]int64, error)
+	GetMessageByID(ctx context.Context, id primitive.ObjectID) (*domain.Message, error)
+	GetMessagesByStatus(ctx context.Context, status domain.MessageStatus, limit int) ([]*domain.Message, error)
+	RequeueMessage(ctx context.Context, id primitive.ObjectID, actor string, from ...domain.MessageStatus) error
+	RetryMessage(ctx context.Context, id primitive.ObjectID, actor, phoneNumber string, from ...domain.MessageStatus) error
+	CancelMessage(ctx context.Context, id primitive.ObjectID, actor string) (*domain.Message, error)
+	GetMessageByProviderID(ctx context.Context, provider, messageID string) (*domain.Message, error)
+	GetMessageByExternalID(ctx context.Context, externalID string) (*domain.Message, error)
+	ReleaseExternalID(ctx context.Context, externalID string, createdBefore time.Time) error
+	GetMessageEvents(ctx context.Context, id primitive.ObjectID) ([]domain.StatusEvent, error)
+	EnsureIndexes(ctx context.Context) error
+	BackfillPriority(ctx context.Context) (int64, error)
 }
 
+// withoutEvents leaves the status history out of message queries; it is only
+// loaded by GetMessageEvents
+var withoutEvents = bson.M{"events": 0}
+
 type messageRepository struct {
 	collection *mongo.Collection
 }
@@ -67,6 +101,10 @@ func (r *messageRepository) SeedSampleData(ctx context.Context) error {
 		},
 	}
 
+	for _, message := range sampleMessages {
+		message.(*domain.Message).ApplyDefaults()
+	}
+
 	_, err = r.collection.InsertMany(ctx, sampleMessages)
 	if err != nil {
 		return fmt.Errorf("failed to insert sample data: %w", err)
@@ -76,10 +114,14 @@ func (r *messageRepository) SeedSampleData(ctx context.Context) error {
 }
 
 func (r *messageRepository) GetPendingMessages(ctx context.Context, limit int) ([]*domain.Message, error) {
-	filter := bson.M{"status": domain.StatusPending}
+	filter := bson.M{
+		"status":  domain.StatusPending,
+		"send_at": notAfter(time.Now()),
+	}
 	opts := options.Find().
 		SetSort(bson.D{{Key: "created_at", Value: 1}}).
-		SetLimit(int64(limit))
+		SetLimit(int64(limit)).
+		SetProjection(withoutEvents)
 
 	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -95,32 +137,205 @@ func (r *messageRepository) GetPendingMessages(ctx context.Context, limit int) (
 	return messages, nil
 }
 
-func (r *messageRepository) GetSentMessages(ctx context.Context) ([]*domain.Message, error) {
-	filter := bson.M{"status": domain.StatusSent}
-	opts := options.Find().SetSort(bson.D{{Key: "sent_at", Value: -1}})
+// ClaimOptions controls how a batch of messages is claimed
+type ClaimOptions struct {
+	Owner string
+	Limit int
+	Lease time.Duration
+	// StarvationAge reserves one slot per batch for the oldest message that has
+	// waited at least this long, whatever its priority. Zero disables the guard.
+	StarvationAge time.Duration
+}
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query sent messages: %w", err)
+var (
+	prioritySort = bson.D{
+		{Key: "priority_rank", Value: -1},
+		{Key: "created_at", Value: 1},
 	}
-	defer cursor.Close(ctx)
+	fifoSort = bson.D{{Key: "created_at", Value: 1}}
+)
 
+// ClaimPendingMessages atomically moves up to opts.Limit messages into the
+// processing state on behalf of opts.Owner, highest priority first. Each message
+// is claimed with its own FindOneAndUpdate, so concurrent replicas can never
+// claim the same document. Messages left in processing by a crashed owner
+// become claimable again once their lease expires.
+func (r *messageRepository) ClaimPendingMessages(ctx context.Context, opts ClaimOptions) ([]*domain.Message, error) {
 	var messages []*domain.Message
-	if err := cursor.All(ctx, &messages); err != nil {
-		return nil, fmt.Errorf("failed to decode messages: %w", err)
+
+	if opts.StarvationAge > 0 && opts.Limit > 1 {
+		starving := bson.M{"created_at": bson.M{"$lte": time.Now().Add(-opts.StarvationAge)}}
+		message, err := r.claimOne(ctx, opts, starving, fifoSort)
+		if err != nil {
+			return messages, err
+		}
+		if message != nil {
+			messages = append(messages, message)
+		}
+	}
+
+	for len(messages) < opts.Limit {
+		message, err := r.claimOne(ctx, opts, nil, prioritySort)
+		if err != nil {
+			return messages, err
+		}
+		if message == nil {
+			break
+		}
+		messages = append(messages, message)
 	}
 
 	return messages, nil
 }
 
-func (r *messageRepository) UpdateMessageStatus(ctx context.Context, message *domain.Message) error {
-	filter := bson.M{"_id": message.ID}
+// claimOne claims the first claimable message matching extra in sort order,
+// or returns nil if there is none
+func (r *messageRepository) claimOne(ctx context.Context, opts ClaimOptions, extra bson.M, sort bson.D) (*domain.Message, error) {
+	now := time.Now()
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{
+				"status":          domain.StatusPending,
+				"send_at":         notAfter(now),
+				"next_attempt_at": notAfter(now),
+			},
+			bson.M{
+				"status":           domain.StatusProcessing,
+				"lease_expires_at": bson.M{"$lte": now},
+			},
+		},
+	}
+	if extra != nil {
+		filter = bson.M{"$and": bson.A{filter, extra}}
+	}
+
+	// A pipeline update, so the event can record the status the message was claimed from
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"status":           domain.StatusProcessing,
+			"claimed_by":       opts.Owner,
+			"lease_expires_at": now.Add(opts.Lease),
+			"events": appendEvent(bson.M{
+				"from":    "$status",
+				"to":      domain.StatusProcessing,
+				"at":      now,
+				"actor":   domain.SchedulerActor(opts.Owner),
+				"attempt": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$attempts", 0}}, 1}},
+			}),
+		}}},
+	}
+	findOpts := options.FindOneAndUpdate().
+		SetSort(sort).
+		SetReturnDocument(options.After).
+		SetProjection(withoutEvents)
+
+	var message domain.Message
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// appendEvent is a pipeline expression appending event to the status history,
+// keeping the newest domain.MaxStatusEvents entries. Values in event are
+// expressions, so literal strings must not start with "$".
+func appendEvent(event bson.M) bson.M {
+	return bson.M{"$slice": bson.A{
+		bson.M{"$concatArrays": bson.A{
+			bson.M{"$ifNull": bson.A{"$events", bson.A{}}},
+			bson.A{event},
+		}},
+		-domain.MaxStatusEvents,
+	}}
+}
+
+// pushEvent is an update operator appending event to the status history
+func pushEvent(event domain.StatusEvent) bson.M {
+	return bson.M{"events": bson.M{
+		"$each":  bson.A{event},
+		"$slice": -domain.MaxStatusEvents,
+	}}
+}
+
+// notAfter matches documents whose field is unset or not later than t
+func notAfter(t time.Time) bson.M {
+	return bson.M{"$not": bson.M{"$gt": t}}
+}
+
+// ReleaseClaims returns messages still held by owner to the pending pool
+func (r *messageRepository) ReleaseClaims(ctx context.Context, owner string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	filter := bson.M{
+		"_id":        bson.M{"$in": ids},
+		"status":     domain.StatusProcessing,
+		"claimed_by": owner,
+	}
 	update := bson.M{
-		"$set": bson.M{
-			"status":     message.Status,
-			"sent_at":    message.SentAt,
-			"message_id": message.MessageID,
+		"$set": bson.M{"status": domain.StatusPending},
+		"$unset": bson.M{
+			"claimed_by":       "",
+			"lease_expires_at": "",
 		},
+		"$push": pushEvent(domain.StatusEvent{
+			From:  domain.StatusProcessing,
+			To:    domain.StatusPending,
+			At:    time.Now(),
+			Actor: domain.SchedulerActor(owner),
+			Error: "released unsent",
+		}),
+	}
+
+	if _, err := r.collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to release claims: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateMessageStatus stores the outcome of a transition and appends event to
+// the history, provided the message is still in status event.From. It returns
+// ErrStatusMismatch when another writer changed the status first and
+// ErrMessageNotFound when the message is gone.
+func (r *messageRepository) UpdateMessageStatus(ctx context.Context, message *domain.Message, event domain.StatusEvent) error {
+	filter := statusFilter(message, event)
+	set := bson.M{
+		"status":          message.Status,
+		"sent_at":         message.SentAt,
+		"message_id":      message.MessageID,
+		"expired_at":      message.ExpiredAt,
+		"attempts":        message.Attempts,
+		"last_error":      message.LastError,
+		"next_attempt_at": message.NextAttemptAt,
+	}
+	if message.Provider != "" {
+		set["provider"] = message.Provider
+	}
+	if message.DeliveryReportedAt != nil {
+		set["delivery_reported_at"] = message.DeliveryReportedAt
+		set["delivery_error_code"] = message.DeliveryErrorCode
+	}
+	if message.SuppressedAt != nil {
+		set["suppressed_at"] = message.SuppressedAt
+		set["suppression_reason"] = message.SuppressionReason
+	}
+
+	update := bson.M{
+		"$set":  set,
+		"$push": pushEvent(event),
+	}
+	if message.Status != domain.StatusProcessing {
+		update["$unset"] = bson.M{
+			"claimed_by":       "",
+			"lease_expires_at": "",
+		}
 	}
 
 	result, err := r.collection.UpdateOne(ctx, filter, update)
@@ -129,20 +344,437 @@ func (r *messageRepository) UpdateMessageStatus(ctx context.Context, message *do
 	}
 
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("message not found: %v", message.ID)
+		return r.missError(ctx, message.ID)
 	}
 
+	if message.Status != domain.StatusProcessing {
+		message.ReleaseClaim()
+	}
 	return nil
 }
 
+// statusFilter matches the message while it is still in status event.From.
+// A message leaving processing must also still carry the claim it was sent
+// under: once its lease expired and another replica reclaimed it, the stale
+// owner's outcome is refused, like ReleaseClaims does.
+func statusFilter(message *domain.Message, event domain.StatusEvent) bson.M {
+	filter := bson.M{
+		"_id":    message.ID,
+		"status": event.From,
+	}
+	if event.From != domain.StatusProcessing {
+		return filter
+	}
+
+	// A message without a claim never matches a claimed document
+	owner := ""
+	if message.ClaimedBy != nil {
+		owner = *message.ClaimedBy
+	}
+	filter["claimed_by"] = owner
+	if message.LeaseExpiresAt != nil {
+		// The same replica may have reclaimed the message under a new lease
+		filter["lease_expires_at"] = *message.LeaseExpiresAt
+	}
+	return filter
+}
+
 func (r *messageRepository) CreateMessage(ctx context.Context, message *domain.Message) error {
-	message.ID = primitive.NewObjectID()
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
 	message.CreatedAt = time.Now()
+	message.ApplyDefaults()
 
 	_, err := r.collection.InsertOne(ctx, message)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateExternalID
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
 	}
 
 	return nil
 }
+
+// InsertError lists the messages of a CreateMessages call that were not
+// stored, by their index in the call; all others were stored
+type InsertError struct {
+	Failed map[int]error
+}
+
+func (e *InsertError) Error() string {
+	return fmt.Sprintf("failed to create %d messages", len(e.Failed))
+}
+
+// CreateMessages inserts messages in one unordered InsertMany, so a message
+// that fails does not stop the ones after it. Partial failures are reported
+// as an *InsertError. Messages keep an id assigned in advance.
+func (r *messageRepository) CreateMessages(ctx context.Context, messages []*domain.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, len(messages))
+	for i, message := range messages {
+		if message.ID.IsZero() {
+			message.ID = primitive.NewObjectID()
+		}
+		message.CreatedAt = now
+		message.ApplyDefaults()
+		docs[i] = message
+	}
+
+	_, err := r.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 && bulkErr.WriteConcernError == nil {
+		failed := make(map[int]error, len(bulkErr.WriteErrors))
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code == duplicateKeyCode {
+				failed[writeErr.Index] = ErrDuplicateExternalID
+				continue
+			}
+			failed[writeErr.Index] = writeErr
+		}
+		return &InsertError{Failed: failed}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create messages: %w", err)
+	}
+
+	return nil
+}
+
+func (r *messageRepository) GetMessageByID(ctx context.Context, id primitive.ObjectID) (*domain.Message, error) {
+	var message domain.Message
+	opts := options.FindOne().SetProjection(withoutEvents)
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// GetMessagesByStatus returns up to limit messages in status, most recent first
+func (r *messageRepository) GetMessagesByStatus(ctx context.Context, status domain.MessageStatus, limit int) ([]*domain.Message, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetProjection(withoutEvents)
+
+	cursor, err := r.collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s messages: %w", status, err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*domain.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// RequeueMessage moves a message back to pending with a fresh attempt budget,
+// provided it is currently in one of the from statuses
+func (r *messageRepository) RequeueMessage(ctx context.Context, id primitive.ObjectID, actor string, from ...domain.MessageStatus) error {
+	return r.requeue(ctx, id, actor, bson.M{}, from)
+}
+
+// RetryMessage requeues a message like RequeueMessage, sending it to
+// phoneNumber instead when that is not empty
+func (r *messageRepository) RetryMessage(ctx context.Context, id primitive.ObjectID, actor, phoneNumber string, from ...domain.MessageStatus) error {
+	set := bson.M{}
+	if phoneNumber != "" {
+		set["phone_number"] = phoneNumber
+	}
+	return r.requeue(ctx, id, actor, set, from)
+}
+
+func (r *messageRepository) requeue(ctx context.Context, id primitive.ObjectID, actor string, set bson.M, from []domain.MessageStatus) error {
+	filter := bson.M{
+		"_id":    id,
+		"status": bson.M{"$in": from},
+	}
+
+	set["status"] = domain.StatusPending
+	set["attempts"] = 0
+	set["events"] = appendEvent(bson.M{
+		"from":  "$status",
+		"to":    domain.StatusPending,
+		"at":    time.Now(),
+		"actor": bson.M{"$literal": actor},
+	})
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: set}},
+		{{Key: "$unset", Value: bson.A{"next_attempt_at", "claimed_by", "lease_expires_at"}}},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return r.missError(ctx, id)
+	}
+
+	return nil
+}
+
+// CancelMessage cancels a message that is still pending. Claiming also only
+// takes pending messages, so exactly one of the two wins and a message the
+// scheduler already picked up returns ErrStatusMismatch.
+func (r *messageRepository) CancelMessage(ctx context.Context, id primitive.ObjectID, actor string) (*domain.Message, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":    id,
+		"status": domain.StatusPending,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       domain.StatusCancelled,
+			"cancelled_at": now,
+		},
+		"$push": pushEvent(domain.StatusEvent{
+			From:  domain.StatusPending,
+			To:    domain.StatusCancelled,
+			At:    now,
+			Actor: actor,
+		}),
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(withoutEvents)
+
+	var message domain.Message
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.missError(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// missError explains why a conditional update of id matched nothing
+func (r *messageRepository) missError(ctx context.Context, id primitive.ObjectID) error {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to look up message: %w", err)
+	}
+	if count == 0 {
+		return ErrMessageNotFound
+	}
+	return ErrStatusMismatch
+}
+
+// GetMessageByProviderID finds a message by the id the provider returned when
+// accepting it. Ids are only unique per provider: without a provider the id
+// must not be used by more than one message.
+func (r *messageRepository) GetMessageByProviderID(ctx context.Context, provider, messageID string) (*domain.Message, error) {
+	filter := bson.M{"message_id": messageID}
+	if provider != "" {
+		filter["provider"] = provider
+	}
+
+	opts := options.Find().SetProjection(withoutEvents).SetLimit(2)
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var messages []*domain.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+
+	switch len(messages) {
+	case 0:
+		return nil, ErrMessageNotFound
+	case 1:
+		return messages[0], nil
+	}
+	return nil, ErrAmbiguousProviderID
+}
+
+// GetMessageByExternalID finds the message holding a client idempotency key
+func (r *messageRepository) GetMessageByExternalID(ctx context.Context, externalID string) (*domain.Message, error) {
+	var message domain.Message
+	opts := options.FindOne().SetProjection(withoutEvents)
+	err := r.collection.FindOne(ctx, bson.M{"external_id": externalID}, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// ReleaseExternalID frees an idempotency key for reuse by removing it from
+// the message holding it, provided that message was created before
+// createdBefore
+func (r *messageRepository) ReleaseExternalID(ctx context.Context, externalID string, createdBefore time.Time) error {
+	filter := bson.M{
+		"external_id": externalID,
+		"created_at":  bson.M{"$lt": createdBefore},
+	}
+	update := bson.M{"$unset": bson.M{"external_id": ""}}
+
+	if _, err := r.collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to release external id: %w", err)
+	}
+
+	return nil
+}
+
+// GetMessageEvents returns the status history of a message, oldest first
+func (r *messageRepository) GetMessageEvents(ctx context.Context, id primitive.ObjectID) ([]domain.StatusEvent, error) {
+	var message struct {
+		Events []domain.StatusEvent `bson:"events"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"events": 1})
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message events: %w", err)
+	}
+
+	if message.Events == nil {
+		return []domain.StatusEvent{}, nil
+	}
+	return message.Events, nil
+}
+
+// CountByStatus returns the number of messages in each status
+func (r *messageRepository) CountByStatus(ctx context.Context) (map[domain.MessageStatus]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$status",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages by status: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status domain.MessageStatus `bson:"_id"`
+		Count  int64                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode status counts: %w", err)
+	}
+
+	counts := make(map[domain.MessageStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	return counts, nil
+}
+
+// BackfillPriority gives messages stored before priorities existed the normal
+// priority and its rank, so the priority sort does not put them behind bulk
+// traffic. It returns the number of messages updated.
+func (r *messageRepository) BackfillPriority(ctx context.Context) (int64, error) {
+	branches := bson.A{}
+	for _, priority := range []domain.MessagePriority{
+		domain.PriorityCritical, domain.PriorityHigh, domain.PriorityNormal, domain.PriorityBulk,
+	} {
+		branches = append(branches, bson.M{
+			"case": bson.M{"$eq": bson.A{"$priority", priority}},
+			"then": priority.Rank(),
+		})
+	}
+
+	filter := bson.M{"priority_rank": bson.M{"$exists": false}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"priority": bson.M{"$ifNull": bson.A{"$priority", domain.PriorityNormal}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"priority_rank": bson.M{"$switch": bson.M{
+				"branches": branches,
+				"default":  domain.PriorityNormal.Rank(),
+			}},
+		}}},
+	}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill message priorities: %w", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// EnsureIndexes creates the indexes used by the dispatcher queries
+func (r *messageRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "created_at", Value: 1},
+				{Key: "send_at", Value: 1},
+				{Key: "next_attempt_at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "priority_rank", Value: -1},
+				{Key: "created_at", Value: 1},
+				{Key: "send_at", Value: 1},
+				{Key: "next_attempt_at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "lease_expires_at", Value: 1},
+			},
+		},
+		{
+			Keys:    bson.D{{Key: "message_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "message_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "external_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+		// Message listing: each filter that narrows well gets its own index
+		// ending in the sort key, plus the id tie-breaker of the page cursor
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "phone_number", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+
+	if _, err := r.collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return fmt.Errorf("failed to create indexes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStatusFilter_FencesProcessingOnClaim(t *testing.T) {
+	owner := "replica-a"
+	lease := time.Date(2024, 1, 1, 12, 5, 0, 0, time.UTC)
+	msg := &domain.Message{ID: primitive.NewObjectID(), ClaimedBy: &owner, LeaseExpiresAt: &lease}
+
+	filter := statusFilter(msg, domain.StatusEvent{From: domain.StatusProcessing, To: domain.StatusSent})
+	if filter["claimed_by"] != owner || filter["lease_expires_at"] != lease {
+		t.Errorf("filter = %v, want it fenced on the claim", filter)
+	}
+
+	// Without a claim in memory the write must not match a claimed document
+	unclaimed := &domain.Message{ID: msg.ID}
+	filter = statusFilter(unclaimed, domain.StatusEvent{From: domain.StatusProcessing, To: domain.StatusSent})
+	if filter["claimed_by"] != "" {
+		t.Errorf("filter = %v, want an owner no claim has", filter)
+	}
+
+	filter = statusFilter(msg, domain.StatusEvent{From: domain.StatusSent, To: domain.StatusDelivered})
+	if _, fenced := filter["claimed_by"]; fenced {
+		t.Errorf("filter = %v, receipts are not fenced on a claim", filter)
+	}
+}
+
+// testRepository connects to the MongoDB at MONGO_TEST_URI, skipping the
+// test when it is not set
+func testRepository(t *testing.T) *messageRepository {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	db, err := database.NewMongoDB(uri, fmt.Sprintf("dispatcher_test_%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		db.Client().Disconnect(context.Background())
+	})
+
+	return NewMessageRepository(db).(*messageRepository)
+}
+
+func TestUpdateMessageStatus_StaleOwnerAfterReclaim(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+
+	if err := repo.CreateMessage(ctx, &domain.Message{
+		PhoneNumber: "+905551111111",
+		Content:     "hello",
+		Status:      domain.StatusPending,
+	}); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+
+	// Replica A claims with a lease that has already run out, so B reclaims
+	stale, err := repo.ClaimPendingMessages(ctx, ClaimOptions{Owner: "replica-a", Limit: 1, Lease: -time.Second})
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("claim by A: %v, %d messages", err, len(stale))
+	}
+	current, err := repo.ClaimPendingMessages(ctx, ClaimOptions{Owner: "replica-b", Limit: 1, Lease: time.Minute})
+	if err != nil || len(current) != 1 {
+		t.Fatalf("reclaim by B: %v, %d messages", err, len(current))
+	}
+
+	msg := stale[0]
+	if err := msg.MarkAsSent("late-a"); err != nil {
+		t.Fatalf("MarkAsSent: %v", err)
+	}
+	err = repo.UpdateMessageStatus(ctx, msg, msg.Event(domain.StatusProcessing, domain.SchedulerActor("replica-a")))
+	if !errors.Is(err, ErrStatusMismatch) {
+		t.Fatalf("stale owner update: err = %v, want ErrStatusMismatch", err)
+	}
+
+	msg = current[0]
+	if err := msg.MarkAsSent("b"); err != nil {
+		t.Fatalf("MarkAsSent: %v", err)
+	}
+	if err := repo.UpdateMessageStatus(ctx, msg, msg.Event(domain.StatusProcessing, domain.SchedulerActor("replica-b"))); err != nil {
+		t.Fatalf("current owner update: %v", err)
+	}
+	if msg.ClaimedBy != nil {
+		t.Error("claim kept after the update was stored")
+	}
+
+	stored, err := repo.GetMessageByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("GetMessageByID: %v", err)
+	}
+	if stored.MessageID == nil || *stored.MessageID != "b" || stored.ClaimedBy != nil {
+		t.Errorf("stored = %+v, want sent by B with the claim cleared", stored)
+	}
+}
+
+func TestBackfillPriority_RanksMessagesWithoutOne(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+
+	// Stored before priorities existed, and with a priority but no rank
+	legacy := primitive.NewObjectID()
+	ranked := primitive.NewObjectID()
+	_, err := repo.collection.InsertMany(ctx, []interface{}{
+		bson.M{"_id": legacy, "phone_number": "+905551111111", "content": "old", "status": domain.StatusPending},
+		bson.M{"_id": ranked, "phone_number": "+905552222222", "content": "high", "status": domain.StatusPending, "priority": domain.PriorityHigh},
+	})
+	if err != nil {
+		t.Fatalf("InsertMany: %v", err)
+	}
+
+	count, err := repo.BackfillPriority(ctx)
+	if err != nil || count != 2 {
+		t.Fatalf("BackfillPriority = %d, %v, want 2", count, err)
+	}
+
+	for id, want := range map[primitive.ObjectID]domain.MessagePriority{legacy: domain.PriorityNormal, ranked: domain.PriorityHigh} {
+		msg, err := repo.GetMessageByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetMessageByID: %v", err)
+		}
+		if msg.Priority != want || msg.PriorityRank != want.Rank() {
+			t.Errorf("message %s = %s rank %d, want %s rank %d", id.Hex(), msg.Priority, msg.PriorityRank, want, want.Rank())
+		}
+	}
+
+	if count, err := repo.BackfillPriority(ctx); err != nil || count != 0 {
+		t.Errorf("second BackfillPriority = %d, %v, want nothing left", count, err)
+	}
+}
+
+func TestGetMessageByProviderID_ScopedByProvider(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+
+	// Two providers handed out the same id
+	primary := primitive.NewObjectID()
+	backup := primitive.NewObjectID()
+	_, err := repo.collection.InsertMany(ctx, []interface{}{
+		bson.M{"_id": primary, "phone_number": "+905551111111", "content": "a", "status": domain.StatusSent, "provider": "primary", "message_id": "42"},
+		bson.M{"_id": backup, "phone_number": "+905552222222", "content": "b", "status": domain.StatusSent, "provider": "backup", "message_id": "42"},
+	})
+	if err != nil {
+		t.Fatalf("InsertMany: %v", err)
+	}
+
+	msg, err := repo.GetMessageByProviderID(ctx, "backup", "42")
+	if err != nil || msg.ID != backup {
+		t.Fatalf("backup receipt matched %v, %v, want the backup message", msg, err)
+	}
+
+	if _, err := repo.GetMessageByProviderID(ctx, "", "42"); !errors.Is(err, ErrAmbiguousProviderID) {
+		t.Errorf("receipt without provider: err = %v, want ErrAmbiguousProviderID", err)
+	}
+	if _, err := repo.GetMessageByProviderID(ctx, "other", "42"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("receipt of another provider: err = %v, want ErrMessageNotFound", err)
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// schedulerSettingsID is the _id of the single settings document
+const schedulerSettingsID = "scheduler"
+
+type SchedulerSettingsRepository interface {
+	GetSettings(ctx context.Context) (*domain.SchedulerSettings, error)
+	SaveSettings(ctx context.Context, settings *domain.SchedulerSettings) error
+}
+
+type schedulerSettingsRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSchedulerSettingsRepository(db *mongo.Database) SchedulerSettingsRepository {
+	return &schedulerSettingsRepository{
+		collection: db.Collection("settings"),
+	}
+}
+
+// GetSettings returns the persisted settings, or nil if none were saved yet
+func (r *schedulerSettingsRepository) GetSettings(ctx context.Context) (*domain.SchedulerSettings, error) {
+	var settings domain.SchedulerSettings
+	err := r.collection.FindOne(ctx, bson.M{"_id": schedulerSettingsID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduler settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+func (r *schedulerSettingsRepository) SaveSettings(ctx context.Context, settings *domain.SchedulerSettings) error {
+	settings.UpdatedAt = time.Now()
+
+	update := bson.M{
+		"$set": bson.M{
+			"interval":      settings.Interval,
+			"batch_size":    settings.BatchSize,
+			"batch_timeout": settings.BatchTimeout,
+			"updated_at":    settings.UpdatedAt,
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+
+	if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": schedulerSettingsID}, update, opts); err != nil {
+		return fmt.Errorf("failed to save scheduler settings: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes when the scheduler fires next
+type Schedule interface {
+	// Next returns the first fire time strictly after t
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// CronSchedule is a standard five-field cron expression
+// (minute hour day-of-month month day-of-week) evaluated in a fixed time zone
+type CronSchedule struct {
+	expr     string
+	location *time.Location
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// Like classic cron, when both day fields are restricted a day matches if either does
+	domRestricted bool
+	dowRestricted bool
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// maxCronSearch bounds Next so an expression that can never match (e.g. 30 February) terminates
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+// ParseCron parses a five-field cron expression. Fields accept "*", numbers,
+// ranges ("9-20"), steps ("*/2", "0-30/5"), lists ("1,15") and, for month
+// and day of week, three-letter names ("MON-FRI", "JAN").
+func ParseCron(expr string, location *time.Location) (*CronSchedule, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+	}
+
+	schedule := &CronSchedule{
+		expr:          strings.Join(fields, " "),
+		location:      location,
+		domRestricted: !strings.HasPrefix(fields[2], "*"),
+		dowRestricted: !strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+	if schedule.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+
+	// 7 is an alias for Sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	return schedule, nil
+}
+
+func (f cronField) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		partBits, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= partBits
+	}
+	return bits, nil
+}
+
+func (f cronField) parsePart(part string) (uint64, error) {
+	rangeSpec, step := part, 1
+	if i := strings.Index(part, "/"); i >= 0 {
+		rangeSpec = part[:i]
+		n, err := strconv.Atoi(part[i+1:])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid step in %s field: %q", f.name, part)
+		}
+		step = n
+	}
+
+	var low, high int
+	switch {
+	case rangeSpec == "*":
+		low, high = f.min, f.max
+	case strings.Contains(rangeSpec, "-"):
+		bounds := strings.SplitN(rangeSpec, "-", 2)
+		var err error
+		if low, err = f.value(bounds[0]); err != nil {
+			return 0, err
+		}
+		if high, err = f.value(bounds[1]); err != nil {
+			return 0, err
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range in %s field: %q", f.name, part)
+		}
+	default:
+		value, err := f.value(rangeSpec)
+		if err != nil {
+			return 0, err
+		}
+		low, high = value, value
+		// "5/15" means starting at 5 every 15 up to the field maximum
+		if step > 1 {
+			high = f.max
+		}
+	}
+
+	var bits uint64
+	for v := low; v <= high; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s field: %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s value %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first matching minute strictly after t, in t's location.
+// It returns the zero time if nothing matches within five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	origLocation := t.Location()
+	t = t.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	deadline := t.Add(maxCronSearch)
+
+	for t.Before(deadline) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.location)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t.In(origLocation)
+	}
+
+	return time.Time{}
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (c *CronSchedule) String() string {
+	return fmt.Sprintf("%s (%s)", c.expr, c.location)
+}
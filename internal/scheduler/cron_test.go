@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"20-10 * * * *",
+		"* * * foo *",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCron(expr, time.UTC); err == nil {
+				t.Errorf("expected error for %q", expr)
+			}
+		})
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	istanbul, err := time.LoadLocation("Europe/Istanbul")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		location *time.Location
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "every minute",
+			expr:     "* * * * *",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC),
+			want:     time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC),
+		},
+		{
+			name:     "step inside business hours",
+			expr:     "*/2 9-20 * * MON-FRI",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 1, 10, 2, 0, 0, time.UTC),
+		},
+		{
+			name:     "after business hours rolls to next morning",
+			expr:     "*/2 9-20 * * MON-FRI",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 1, 20, 59, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "friday evening rolls to monday",
+			expr:     "*/2 9-20 * * MON-FRI",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 5, 21, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "evaluated in configured time zone",
+			expr:     "0 9 * * *",
+			location: istanbul,
+			from:     time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			expr:     "0 0 15 * SUN",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			expr:     "0 12 29 2 *",
+			location: time.UTC,
+			from:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want:     time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never matches",
+			expr:     "0 0 30 2 *",
+			location: time.UTC,
+			from:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:     time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr, tt.location)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBatchRuns bounds the in-memory batch history
+const maxBatchRuns = 100
+
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// BatchRun records the outcome of a single batch
+type BatchRun struct {
+	Trigger    string    `json:"trigger"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Claimed    int       `json:"claimed"`
+	Sent       int       `json:"sent"`
+	Failed     int       `json:"failed"`
+	Expired    int       `json:"expired"`
+	// Suppressed counts duplicates of recent messages that were not sent
+	Suppressed int `json:"suppressed"`
+	// DeadLettered is the subset of Failed that will not be retried
+	DeadLettered int `json:"dead_lettered"`
+	// Deferred counts claims handed back unsent, e.g. while the circuit was open
+	Deferred int    `json:"deferred"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runHistory is a fixed-size ring buffer of batch runs
+type runHistory struct {
+	mu    sync.Mutex
+	runs  []BatchRun
+	next  int
+	count int
+}
+
+func newRunHistory(size int) *runHistory {
+	return &runHistory{runs: make([]BatchRun, size)}
+}
+
+func (h *runHistory) add(run BatchRun) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.runs[h.next] = run
+	h.next = (h.next + 1) % len(h.runs)
+	if h.count < len(h.runs) {
+		h.count++
+	}
+}
+
+// list returns up to limit runs, newest first
+func (h *runHistory) list(limit int) []BatchRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if limit <= 0 || limit > h.count {
+		limit = h.count
+	}
+
+	runs := make([]BatchRun, 0, limit)
+	for i := 1; i <= limit; i++ {
+		idx := (h.next - i + len(h.runs)) % len(h.runs)
+		runs = append(runs, h.runs[idx])
+	}
+	return runs
+}
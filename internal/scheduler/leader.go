@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/redis"
+)
+
+// LeaderElector decides which instance is allowed to process batches
+type LeaderElector interface {
+	// Campaign acquires or renews leadership and returns the new lease expiry
+	Campaign(ctx context.Context) (bool, time.Time, error)
+	// Resign gives up leadership so another instance can take over immediately
+	Resign(ctx context.Context) error
+	// Leader returns the instance currently holding leadership
+	Leader(ctx context.Context) (*LeaderInfo, error)
+	// InstanceID identifies this instance
+	InstanceID() string
+	// TTL is the lifetime of a leadership lease
+	TTL() time.Duration
+}
+
+// LeaderInfo describes the current leadership lease
+type LeaderInfo struct {
+	InstanceID     string     `json:"instance_id,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+}
+
+// RedisLeaderElector elects a leader with a Redis lock that expires unless renewed
+type RedisLeaderElector struct {
+	client     *redis.Client
+	key        string
+	instanceID string
+	ttl        time.Duration
+}
+
+func NewRedisLeaderElector(client *redis.Client, key, instanceID string, ttl time.Duration) *RedisLeaderElector {
+	return &RedisLeaderElector{
+		client:     client,
+		key:        key,
+		instanceID: instanceID,
+		ttl:        ttl,
+	}
+}
+
+func (e *RedisLeaderElector) Campaign(ctx context.Context) (bool, time.Time, error) {
+	// Take the timestamp before the round trip so the local lease never outlives the Redis one
+	start := time.Now()
+
+	acquired, err := e.client.AcquireLock(ctx, e.key, e.instanceID, e.ttl)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("leader campaign failed: %w", err)
+	}
+	if !acquired {
+		return false, time.Time{}, nil
+	}
+
+	return true, start.Add(e.ttl), nil
+}
+
+func (e *RedisLeaderElector) Resign(ctx context.Context) error {
+	return e.client.ReleaseLock(ctx, e.key, e.instanceID)
+}
+
+func (e *RedisLeaderElector) Leader(ctx context.Context) (*LeaderInfo, error) {
+	owner, ttl, err := e.client.GetLockHolder(ctx, e.key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &LeaderInfo{InstanceID: owner}
+	if owner != "" && ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		info.LeaseExpiresAt = &expiresAt
+	}
+
+	return info, nil
+}
+
+func (e *RedisLeaderElector) InstanceID() string {
+	return e.instanceID
+}
+
+func (e *RedisLeaderElector) TTL() time.Duration {
+	return e.ttl
+}
@@ -2,26 +2,65 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
 )
 
+var (
+	// ErrBatchInProgress is returned when a batch is requested while another one runs
+	ErrBatchInProgress = errors.New("a batch is already in progress")
+	// ErrPaused is returned when a batch is requested while sending is paused
+	ErrPaused = errors.New("scheduler is paused")
+	// ErrNotLeader is returned when a batch is requested from an instance
+	// that does not hold scheduler leadership
+	ErrNotLeader = errors.New("this instance is not the scheduler leader")
+	// ErrStopping is returned when a batch is requested while Stop runs
+	ErrStopping = errors.New("scheduler is stopping")
+)
+
+// defaultBatchTimeout bounds a single batch unless configured otherwise
+const defaultBatchTimeout = 2 * time.Minute
+
 // Scheduler manages message sending at specified intervals
 type Scheduler struct {
 	messageService service.MessageService
-	interval       time.Duration
-	batchSize      int
 	logger         *logger.Logger
 
+	settingsMu    sync.RWMutex
+	interval      time.Duration
+	batchSize     int
+	batchTimeout  time.Duration
+	claimLease    time.Duration
+	settingsStore repository.SchedulerSettingsRepository
+	reconfigChan  chan struct{}
+
+	// batchMu guarantees tick-driven and manual batches never overlap
+	batchMu sync.Mutex
+	history *runHistory
+	// manual tracks triggered batches so Stop can wait for them
+	manual sync.WaitGroup
+
+	// pausedReason is set when a fatal delivery error stops sending until an operator resumes it
+	pauseMu      sync.RWMutex
+	pausedReason string
+
 	mu        sync.Mutex
 	running   bool
+	stopping  bool
 	stopChan  chan struct{}
 	doneChan  chan struct{}
 	ctx       context.Context
 	cancelCtx context.CancelFunc
+
+	schedule       Schedule
+	elector        LeaderElector
+	leaderMu       sync.RWMutex
+	leaseExpiresAt time.Time
 }
 
 func NewScheduler(
@@ -36,6 +75,9 @@ func NewScheduler(
 		messageService: messageService,
 		interval:       interval,
 		batchSize:      batchSize,
+		batchTimeout:   defaultBatchTimeout,
+		reconfigChan:   make(chan struct{}, 1),
+		history:        newRunHistory(maxBatchRuns),
 		logger:         logger,
 		stopChan:       make(chan struct{}),
 		doneChan:       make(chan struct{}),
@@ -44,6 +86,58 @@ func NewScheduler(
 	}
 }
 
+// SetLeaderElector enables leader election. Only the instance holding
+// leadership processes batches; the others keep campaigning and take over
+// once the leader's lease expires. Must be called before Start.
+func (s *Scheduler) SetLeaderElector(elector LeaderElector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elector = elector
+}
+
+// SetSchedule replaces the fixed interval with a schedule such as a cron
+// expression. Must be called before Start.
+func (s *Scheduler) SetSchedule(schedule Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedule = schedule
+}
+
+// HasSchedule reports whether batches follow a schedule such as a cron
+// expression, in which case the interval setting is not used
+func (s *Scheduler) HasSchedule() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schedule != nil
+}
+
+// Describe returns a human readable description of when batches run
+func (s *Scheduler) Describe() string {
+	if s.schedule != nil {
+		return s.schedule.String()
+	}
+	return "every " + s.Settings().Interval.String()
+}
+
+// NextRuns returns the next n fire times of the cron schedule, or nil when
+// running on a fixed interval
+func (s *Scheduler) NextRuns(n int) []time.Time {
+	if s.schedule == nil {
+		return nil
+	}
+
+	runs := make([]time.Time, 0, n)
+	next := time.Now()
+	for i := 0; i < n; i++ {
+		next = s.schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+	}
+	return runs
+}
+
 func (s *Scheduler) Start() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -55,34 +149,47 @@ func (s *Scheduler) Start() error {
 
 	s.stopChan = make(chan struct{})
 	s.doneChan = make(chan struct{})
-	s.ctx, s.cancelCtx = context.WithCancel(context.Background())
+	// A batch triggered while stopped keeps running under the same context
+	if s.ctx.Err() != nil {
+		s.ctx, s.cancelCtx = context.WithCancel(context.Background())
+	}
 
 	s.running = true
-	s.logger.Info("Starting scheduler with interval: %v, batch size: %d", s.interval, s.batchSize)
+	s.Resume()
+	s.logger.Info("Starting scheduler %s, batch size: %d", s.Describe(), s.Settings().BatchSize)
 
 	go s.run()
 
 	return nil
 }
 
+// Stop ends the loop and cancels any batch in flight, triggered ones
+// included, and returns once they have wound down
 func (s *Scheduler) Stop() error {
 	s.mu.Lock()
-	if !s.running {
+	running := s.running
+	s.stopping = true
+	s.cancelCtx()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.stopping = false
 		s.mu.Unlock()
+	}()
+
+	if !running {
+		s.manual.Wait()
 		s.logger.Info("Scheduler is not running")
 		return nil
 	}
-	s.mu.Unlock()
 
 	s.logger.Info("Stopping scheduler...")
 
 	close(s.stopChan)
-	s.cancelCtx()
 	<-s.doneChan
-
-	s.mu.Lock()
-	s.running = false
-	s.mu.Unlock()
+	s.manual.Wait()
 
 	s.logger.Info("Scheduler stopped")
 	return nil
@@ -94,14 +201,86 @@ func (s *Scheduler) IsRunning() bool {
 	return s.running
 }
 
+// IsLeader reports whether this instance may process batches. Without leader
+// election every instance is considered leader.
+func (s *Scheduler) IsLeader() bool {
+	if s.elector == nil {
+		return true
+	}
+
+	s.leaderMu.RLock()
+	defer s.leaderMu.RUnlock()
+	return time.Now().Before(s.leaseExpiresAt)
+}
+
+// LeaderStatus describes leader election as seen from this instance
+type LeaderStatus struct {
+	Enabled        bool       `json:"enabled"`
+	InstanceID     string     `json:"instance_id,omitempty"`
+	IsLeader       bool       `json:"is_leader"`
+	Leader         string     `json:"leader,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// Error is why the current leader could not be looked up
+	Error string `json:"error,omitempty"`
+}
+
+// LeaderStatus reports the leader election state. When the current leader
+// cannot be looked up it still returns what this instance knows, along with
+// the error.
+func (s *Scheduler) LeaderStatus(ctx context.Context) (*LeaderStatus, error) {
+	if s.elector == nil {
+		return &LeaderStatus{Enabled: false, IsLeader: true}, nil
+	}
+
+	status := &LeaderStatus{
+		Enabled:    true,
+		InstanceID: s.elector.InstanceID(),
+		IsLeader:   s.IsLeader(),
+	}
+
+	info, err := s.elector.Leader(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	status.Leader = info.InstanceID
+	status.LeaseExpiresAt = info.LeaseExpiresAt
+	return status, nil
+}
+
 func (s *Scheduler) run() {
 	defer close(s.doneChan)
 
-	ticker := time.NewTicker(s.interval)
-	defer ticker.Stop()
-
 	s.logger.Info("Scheduler loop started")
 
+	if s.elector != nil {
+		var wg sync.WaitGroup
+		s.campaign(s.ctx)
+
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			s.maintainLeadership(ctx)
+		}(s.ctx)
+
+		defer func() {
+			wg.Wait()
+			s.resign()
+		}()
+	}
+
+	if s.schedule != nil {
+		s.runSchedule()
+		return
+	}
+	s.runInterval()
+}
+
+func (s *Scheduler) runInterval() {
+	interval := s.Settings().Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	// Process first batch immediately
 	s.processBatch()
 
@@ -109,7 +288,37 @@ func (s *Scheduler) run() {
 		select {
 		case <-ticker.C:
 			s.processBatch()
+		case <-s.reconfigChan:
+			// Only reached between batches, so an in-flight batch always completes
+			if next := s.Settings().Interval; next != interval {
+				interval = next
+				ticker.Reset(interval)
+				s.logger.Info("Scheduler interval changed to %v", interval)
+			}
+		case <-s.stopChan:
+			s.logger.Info("Scheduler received stop signal")
+			return
+		}
+	}
+}
+
+// runSchedule waits for each fire time of the schedule. Unlike the interval
+// loop it does not run a batch on start, so sends stay inside the schedule.
+func (s *Scheduler) runSchedule() {
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Error("Schedule %s has no upcoming fire time", s.schedule)
+			<-s.stopChan
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			s.processBatch()
 		case <-s.stopChan:
+			timer.Stop()
 			s.logger.Info("Scheduler received stop signal")
 			return
 		}
@@ -117,12 +326,185 @@ func (s *Scheduler) run() {
 }
 
 func (s *Scheduler) processBatch() {
-	s.logger.Info("Processing batch of %d messages", s.batchSize)
+	if !s.IsLeader() {
+		s.logger.Info("Not the scheduler leader, skipping batch")
+		return
+	}
+
+	if reason := s.PausedReason(); reason != "" {
+		s.logger.Info("Scheduler paused (%s), skipping batch", reason)
+		return
+	}
+
+	if !s.batchMu.TryLock() {
+		s.logger.Info("Previous batch still running, skipping tick")
+		return
+	}
+	defer s.batchMu.Unlock()
+
+	s.executeBatch(s.ctx, TriggerSchedule)
+}
+
+// Trigger runs one batch right away in the background, independent of the
+// schedule and of whether the scheduler is running. It fails with
+// ErrBatchInProgress instead of overlapping another batch. With leader
+// election only the leader may send, so a stopped or following instance
+// gets ErrNotLeader. The batch is cancelled and waited for by Stop.
+func (s *Scheduler) Trigger() error {
+	if !s.IsLeader() {
+		return ErrNotLeader
+	}
+
+	if s.PausedReason() != "" {
+		return ErrPaused
+	}
+
+	if !s.batchMu.TryLock() {
+		return ErrBatchInProgress
+	}
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		s.batchMu.Unlock()
+		return ErrStopping
+	}
+	if s.ctx.Err() != nil {
+		// Stopped earlier; the next Start or Stop takes over this context
+		s.ctx, s.cancelCtx = context.WithCancel(context.Background())
+	}
+	ctx := s.ctx
+	s.manual.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.manual.Done()
+		defer s.batchMu.Unlock()
+		s.executeBatch(ctx, TriggerManual)
+	}()
+
+	return nil
+}
+
+// Pause stops batches from running until Resume is called. The loop keeps
+// running, so leadership and the schedule are unaffected.
+func (s *Scheduler) Pause(reason string) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	s.pausedReason = reason
+	s.logger.Error("ALERT: scheduler paused: %s", reason)
+}
+
+// Resume lets batches run again after a pause
+func (s *Scheduler) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if s.pausedReason != "" {
+		s.logger.Info("Scheduler resumed")
+	}
+	s.pausedReason = ""
+}
 
-	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Minute)
+// PausedReason returns why the scheduler is paused, or an empty string
+func (s *Scheduler) PausedReason() string {
+	s.pauseMu.RLock()
+	defer s.pauseMu.RUnlock()
+	return s.pausedReason
+}
+
+// Runs returns up to limit recent batch runs, newest first
+func (s *Scheduler) Runs(limit int) []BatchRun {
+	return s.history.list(limit)
+}
+
+// executeBatch must be called with batchMu held
+func (s *Scheduler) executeBatch(parent context.Context, trigger string) {
+	settings := s.Settings()
+	s.logger.Info("Processing batch of %d messages (%s)", settings.BatchSize, trigger)
+
+	ctx, cancel := context.WithTimeout(parent, settings.BatchTimeout)
 	defer cancel()
 
-	if err := s.messageService.ProcessPendingMessages(ctx, s.batchSize); err != nil {
+	run := BatchRun{
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+
+	result, err := s.messageService.ProcessPendingMessages(ctx, settings.BatchSize)
+	if result != nil {
+		run.Claimed = result.Claimed
+		run.Sent = result.Sent
+		run.Failed = result.Failed
+		run.Expired = result.Expired
+		run.Suppressed = result.Suppressed
+		run.DeadLettered = result.DeadLettered
+		run.Deferred = result.Deferred
+	}
+	if err != nil {
 		s.logger.Error("Failed to process pending messages: %v", err)
+		run.Error = err.Error()
+
+		if service.IsFatal(err) {
+			s.Pause(err.Error())
+		}
+	}
+
+	run.FinishedAt = time.Now()
+	s.history.add(run)
+}
+
+// maintainLeadership renews the lease well before it expires, or keeps
+// campaigning while another instance is leader
+func (s *Scheduler) maintainLeadership(ctx context.Context) {
+	ticker := time.NewTicker(s.elector.TTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.campaign(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) campaign(ctx context.Context) {
+	wasLeader := s.IsLeader()
+
+	acquired, expiresAt, err := s.elector.Campaign(ctx)
+	if err != nil {
+		// Keep the current lease; it lapses on its own if Redis stays unreachable
+		s.logger.Error("Leader election failed: %v", err)
+		return
+	}
+
+	s.leaderMu.Lock()
+	if acquired {
+		s.leaseExpiresAt = expiresAt
+	} else {
+		s.leaseExpiresAt = time.Time{}
+	}
+	s.leaderMu.Unlock()
+
+	if acquired && !wasLeader {
+		s.logger.Info("Instance %s acquired scheduler leadership", s.elector.InstanceID())
+	} else if !acquired && wasLeader {
+		s.logger.Info("Instance %s lost scheduler leadership", s.elector.InstanceID())
+	}
+}
+
+func (s *Scheduler) resign() {
+	s.leaderMu.Lock()
+	s.leaseExpiresAt = time.Time{}
+	s.leaderMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.elector.Resign(ctx); err != nil {
+		s.logger.Error("Failed to resign leadership: %v", err)
 	}
 }
@@ -2,32 +2,58 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/service"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
 )
 
+// mockMessageService only implements what the scheduler calls; the embedded
+// interface panics if anything else is used
 type mockMessageService struct {
-	callCount int
+	service.MessageService
+	calls atomic.Int32
+	// batches, when set, receives a value for every batch
+	batches chan struct{}
 }
 
-func (m *mockMessageService) ProcessPendingMessages(ctx context.Context, batchSize int) error {
-	m.callCount++
-	return nil
+func newMockMessageService() *mockMessageService {
+	return &mockMessageService{batches: make(chan struct{}, 100)}
 }
 
-func (m *mockMessageService) GetSentMessages(ctx context.Context) ([]*domain.Message, error) {
-	return nil, nil
+func (m *mockMessageService) ProcessPendingMessages(ctx context.Context, batchSize int) (*service.BatchResult, error) {
+	m.called()
+	return &service.BatchResult{}, nil
 }
 
-func (m *mockMessageService) CreateMessage(ctx context.Context, phoneNumber, content string) error {
-	return nil
+func (m *mockMessageService) called() {
+	m.calls.Add(1)
+	select {
+	case m.batches <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mockMessageService) callCount() int {
+	return int(m.calls.Load())
+}
+
+// waitForBatch fails the test unless a batch runs within timeout
+func (m *mockMessageService) waitForBatch(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-m.batches:
+	case <-time.After(timeout):
+		t.Fatalf("no batch within %v (calls: %d)", timeout, m.callCount())
+	}
 }
 
 func TestScheduler_StartStop(t *testing.T) {
-	mock := &mockMessageService{}
+	mock := newMockMessageService()
 	log := logger.New()
 
 	s := NewScheduler(mock, 100*time.Millisecond, 2, log)
@@ -41,11 +67,9 @@ func TestScheduler_StartStop(t *testing.T) {
 		t.Error("should be running after start")
 	}
 
-	time.Sleep(250 * time.Millisecond)
-
-	if mock.callCount < 2 {
-		t.Errorf("expected at least 2 calls, got %d", mock.callCount)
-	}
+	// The first batch runs on start, the second on the first tick
+	mock.waitForBatch(t, time.Second)
+	mock.waitForBatch(t, time.Second)
 
 	s.Stop()
 	if s.IsRunning() {
@@ -70,3 +94,272 @@ func TestScheduler_RestartCapability(t *testing.T) {
 		t.Error("should be stopped")
 	}
 }
+
+type fakeElector struct {
+	leader    bool
+	leaderErr error
+}
+
+func (f *fakeElector) Campaign(ctx context.Context) (bool, time.Time, error) {
+	if !f.leader {
+		return false, time.Time{}, nil
+	}
+	return true, time.Now().Add(f.TTL()), nil
+}
+
+func (f *fakeElector) Resign(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeElector) Leader(ctx context.Context) (*LeaderInfo, error) {
+	if f.leaderErr != nil {
+		return nil, f.leaderErr
+	}
+	return &LeaderInfo{}, nil
+}
+
+func (f *fakeElector) InstanceID() string {
+	return "test-instance"
+}
+
+func (f *fakeElector) TTL() time.Duration {
+	return 3 * time.Second
+}
+
+func TestScheduler_LeaderElection(t *testing.T) {
+	tests := []struct {
+		name      string
+		leader    bool
+		wantCalls bool
+	}{
+		{name: "leader processes batches", leader: true, wantCalls: true},
+		{name: "follower skips batches", leader: false, wantCalls: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockMessageService{}
+			s := NewScheduler(mock, 50*time.Millisecond, 2, logger.New())
+			s.SetLeaderElector(&fakeElector{leader: tt.leader})
+
+			s.Start()
+			time.Sleep(120 * time.Millisecond)
+			s.Stop()
+
+			if got := mock.callCount() > 0; got != tt.wantCalls {
+				t.Errorf("processed = %v, want %v (calls: %d)", got, tt.wantCalls, mock.callCount())
+			}
+
+			if s.IsLeader() {
+				t.Error("should give up leadership after stop")
+			}
+		})
+	}
+}
+
+func TestScheduler_UpdateSettingsWhileRunning(t *testing.T) {
+	mock := newMockMessageService()
+	s := NewScheduler(mock, time.Hour, 2, logger.New())
+
+	s.Start()
+	defer s.Stop()
+
+	// Only the initial batch runs on an hourly interval
+	mock.waitForBatch(t, time.Second)
+
+	_, err := s.UpdateSettings(context.Background(), domain.SchedulerSettings{
+		Interval:     time.Second,
+		BatchSize:    5,
+		BatchTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+
+	// Only the new one second interval can run another batch this soon
+	mock.waitForBatch(t, 3*time.Second)
+
+	if _, err := s.UpdateSettings(context.Background(), domain.SchedulerSettings{Interval: time.Second}); err == nil {
+		t.Error("expected validation error for zero batch size")
+	}
+}
+
+func TestScheduler_BatchTimeoutBelowClaimLease(t *testing.T) {
+	s := NewScheduler(&mockMessageService{}, time.Minute, 2, logger.New())
+	s.SetClaimLease(5 * time.Minute)
+
+	settings := domain.SchedulerSettings{Interval: time.Minute, BatchSize: 10, BatchTimeout: 10 * time.Minute}
+	if _, err := s.UpdateSettings(context.Background(), settings); !errors.Is(err, domain.ErrBatchTimeoutTooLong) {
+		t.Errorf("timeout over the lease: err = %v, want ErrBatchTimeoutTooLong", err)
+	}
+
+	settings.BatchTimeout = 5 * time.Minute
+	if err := s.ValidateSettings(settings); !errors.Is(err, domain.ErrBatchTimeoutTooLong) {
+		t.Errorf("timeout equal to the lease: err = %v, want ErrBatchTimeoutTooLong", err)
+	}
+
+	settings.BatchTimeout = 4 * time.Minute
+	if _, err := s.UpdateSettings(context.Background(), settings); err != nil {
+		t.Errorf("timeout under the lease: %v", err)
+	}
+	if got := s.Settings().BatchTimeout; got != 4*time.Minute {
+		t.Errorf("batch timeout = %v, want 4m", got)
+	}
+}
+
+type blockingMessageService struct {
+	mockMessageService
+	release chan struct{}
+}
+
+func (m *blockingMessageService) ProcessPendingMessages(ctx context.Context, batchSize int) (*service.BatchResult, error) {
+	<-m.release
+	return &service.BatchResult{Claimed: 2, Sent: 1, Failed: 1}, nil
+}
+
+func TestScheduler_TriggerAndRuns(t *testing.T) {
+	mock := &blockingMessageService{release: make(chan struct{})}
+	s := NewScheduler(mock, time.Hour, 2, logger.New())
+
+	if err := s.Trigger(); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+
+	if err := s.Trigger(); err != ErrBatchInProgress {
+		t.Errorf("overlapping trigger = %v, want %v", err, ErrBatchInProgress)
+	}
+
+	close(mock.release)
+
+	deadline := time.Now().Add(time.Second)
+	for len(s.Runs(0)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	runs := s.Runs(0)
+	if len(runs) != 1 {
+		t.Fatalf("runs = %d, want 1", len(runs))
+	}
+
+	run := runs[0]
+	if run.Trigger != TriggerManual || run.Claimed != 2 || run.Sent != 1 || run.Failed != 1 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+}
+
+func TestRunHistory_Bounded(t *testing.T) {
+	h := newRunHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.add(BatchRun{Claimed: i})
+	}
+
+	runs := h.list(0)
+	if len(runs) != 3 {
+		t.Fatalf("len = %d, want 3", len(runs))
+	}
+
+	for i, want := range []int{5, 4, 3} {
+		if runs[i].Claimed != want {
+			t.Errorf("runs[%d].Claimed = %d, want %d", i, runs[i].Claimed, want)
+		}
+	}
+
+	if got := h.list(2); len(got) != 2 {
+		t.Errorf("limited len = %d, want 2", len(got))
+	}
+}
+
+type fatalMessageService struct {
+	mockMessageService
+}
+
+func (m *fatalMessageService) ProcessPendingMessages(ctx context.Context, batchSize int) (*service.BatchResult, error) {
+	m.called()
+	return &service.BatchResult{}, &service.DeliveryError{Kind: service.ErrorKindAuth, StatusCode: 401}
+}
+
+func TestScheduler_PausesOnFatalError(t *testing.T) {
+	mock := &fatalMessageService{}
+	s := NewScheduler(mock, 30*time.Millisecond, 2, logger.New())
+
+	s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	if s.PausedReason() == "" {
+		t.Fatal("scheduler should be paused after a fatal error")
+	}
+	if mock.callCount() != 1 {
+		t.Errorf("calls = %d, want 1 while paused", mock.callCount())
+	}
+	if err := s.Trigger(); err != ErrPaused {
+		t.Errorf("Trigger = %v, want %v", err, ErrPaused)
+	}
+
+	s.Resume()
+	if s.PausedReason() != "" {
+		t.Error("scheduler should resume")
+	}
+	s.Stop()
+}
+
+// cancellableMessageService runs a batch until its context is cancelled
+type cancellableMessageService struct {
+	mockMessageService
+	started chan struct{}
+}
+
+func (m *cancellableMessageService) ProcessPendingMessages(ctx context.Context, batchSize int) (*service.BatchResult, error) {
+	close(m.started)
+	<-ctx.Done()
+	return &service.BatchResult{}, ctx.Err()
+}
+
+func TestScheduler_StopCancelsTriggeredBatch(t *testing.T) {
+	mock := &cancellableMessageService{started: make(chan struct{})}
+	s := NewScheduler(mock, time.Hour, 2, logger.New())
+
+	if err := s.Trigger(); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	<-mock.started
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the triggered batch")
+	}
+
+	// Stop returns only after the batch has been recorded
+	runs := s.Runs(0)
+	if len(runs) != 1 || runs[0].Error == "" {
+		t.Errorf("runs = %+v, want one cancelled run", runs)
+	}
+}
+
+func TestScheduler_TriggerRequiresLeadership(t *testing.T) {
+	s := NewScheduler(&mockMessageService{}, time.Hour, 2, logger.New())
+	s.SetLeaderElector(&fakeElector{leader: false})
+
+	if err := s.Trigger(); err != ErrNotLeader {
+		t.Errorf("Trigger on a follower = %v, want %v", err, ErrNotLeader)
+	}
+}
+
+func TestScheduler_LeaderStatusWithoutRedis(t *testing.T) {
+	s := NewScheduler(&mockMessageService{}, time.Hour, 2, logger.New())
+	s.SetLeaderElector(&fakeElector{leaderErr: errors.New("redis down")})
+
+	status, err := s.LeaderStatus(context.Background())
+	if err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	if status == nil || !status.Enabled || status.InstanceID != "test-instance" {
+		t.Errorf("status = %+v, want what this instance knows", status)
+	}
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+)
+
+// SetBatchTimeout sets how long a single batch may run. Must be called before Start.
+func (s *Scheduler) SetBatchTimeout(timeout time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.batchTimeout = timeout
+}
+
+// SetClaimLease sets the lease on claimed messages, which every batch
+// timeout must stay below. Must be called before Start.
+func (s *Scheduler) SetClaimLease(lease time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.claimLease = lease
+}
+
+// ValidateSettings checks settings on their own and against the claim lease
+func (s *Scheduler) ValidateSettings(settings domain.SchedulerSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	s.settingsMu.RLock()
+	lease := s.claimLease
+	s.settingsMu.RUnlock()
+	return settings.ValidateLease(lease)
+}
+
+// SetSettingsStore persists runtime changes to the settings so they survive a restart
+func (s *Scheduler) SetSettingsStore(store repository.SchedulerSettingsRepository) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.settingsStore = store
+}
+
+// Settings returns the interval, batch size and batch timeout currently in effect
+func (s *Scheduler) Settings() domain.SchedulerSettings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return domain.SchedulerSettings{
+		Interval:     s.interval,
+		BatchSize:    s.batchSize,
+		BatchTimeout: s.batchTimeout,
+	}
+}
+
+// LoadSettings applies previously persisted settings over the configured defaults
+func (s *Scheduler) LoadSettings(ctx context.Context) error {
+	if s.settingsStore == nil {
+		return nil
+	}
+
+	settings, err := s.settingsStore.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+	if settings == nil {
+		return nil
+	}
+
+	if err := s.ValidateSettings(*settings); err != nil {
+		return fmt.Errorf("persisted scheduler settings are invalid: %w", err)
+	}
+
+	s.applySettings(settings)
+	s.logger.Info("Loaded scheduler settings: interval %v, batch size %d, batch timeout %v",
+		settings.Interval, settings.BatchSize, settings.BatchTimeout)
+	return nil
+}
+
+// UpdateSettings validates, persists and applies new settings while the
+// scheduler runs. The loop picks them up after the in-flight batch finishes.
+func (s *Scheduler) UpdateSettings(ctx context.Context, settings domain.SchedulerSettings) (*domain.SchedulerSettings, error) {
+	if err := s.ValidateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	if s.settingsStore != nil {
+		if err := s.settingsStore.SaveSettings(ctx, &settings); err != nil {
+			return nil, err
+		}
+	}
+
+	s.applySettings(&settings)
+	s.logger.Info("Scheduler settings updated: interval %v, batch size %d, batch timeout %v",
+		settings.Interval, settings.BatchSize, settings.BatchTimeout)
+
+	return &settings, nil
+}
+
+func (s *Scheduler) applySettings(settings *domain.SchedulerSettings) {
+	s.settingsMu.Lock()
+	s.interval = settings.Interval
+	s.batchSize = settings.BatchSize
+	s.batchTimeout = settings.BatchTimeout
+	s.settingsMu.Unlock()
+
+	// Wake the loop without blocking; one pending signal is enough
+	select {
+	case s.reconfigChan <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+)
+
+// ErrCircuitOpen is returned without contacting the provider while the breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// CircuitBreakerConfig decides when the provider is considered down
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after this many failures in a row; zero disables
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when this share of the last WindowSize
+	// sends failed, once at least MinRequests were made; zero disables
+	FailureRate float64
+	WindowSize  int
+	MinRequests int
+	// OpenTimeout is how long the circuit stays open before a probe is allowed
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of concurrent probes allowed while half-open
+	HalfOpenRequests int
+}
+
+// BreakerSnapshot is the breaker state as reported by the status endpoints
+type BreakerSnapshot struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	FailureRate         float64      `json:"failure_rate"`
+	Requests            int          `json:"requests"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	NextProbeAt         *time.Time   `json:"next_probe_at,omitempty"`
+}
+
+// CircuitBreaker stops calls to the provider after repeated transient
+// failures and lets a few probes through once OpenTimeout has passed
+type CircuitBreaker struct {
+	next   WebhookClient
+	config CircuitBreakerConfig
+	clock  Clock
+
+	mu               sync.Mutex
+	state            BreakerState
+	consecutive      int
+	window           []bool
+	windowNext       int
+	windowCount      int
+	openedAt         time.Time
+	halfOpenInFlight int
+	// generation changes with every state change, so a call that outlives
+	// the state it was allowed in is not counted against the new one
+	generation uint64
+}
+
+func NewCircuitBreaker(next WebhookClient, config CircuitBreakerConfig, clock Clock) *CircuitBreaker {
+	if clock == nil {
+		clock = realClock{}
+	}
+	if config.WindowSize < 1 {
+		config.WindowSize = 1
+	}
+	if config.HalfOpenRequests < 1 {
+		config.HalfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		next:   next,
+		config: config,
+		clock:  clock,
+		state:  BreakerClosed,
+		window: make([]bool, config.WindowSize),
+	}
+}
+
+func (b *CircuitBreaker) SendMessage(ctx context.Context, phoneNumber, content string) (*domain.WebhookResponse, error) {
+	generation, ok := b.allow()
+	if !ok {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := b.next.SendMessage(ctx, phoneNumber, content)
+	b.record(ctx, generation, err)
+	return resp, err
+}
+
+// Snapshot returns the current state for reporting
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := BreakerSnapshot{
+		State:               b.currentState(),
+		ConsecutiveFailures: b.consecutive,
+		FailureRate:         b.failureRate(),
+		Requests:            b.windowCount,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		nextProbeAt := b.openedAt.Add(b.config.OpenTimeout)
+		snapshot.OpenedAt = &openedAt
+		snapshot.NextProbeAt = &nextProbeAt
+	}
+
+	return snapshot
+}
+
+// currentState moves an open circuit to half-open once its timeout passed.
+// Must be called with mu held.
+func (b *CircuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && !b.clock.Now().Before(b.openedAt.Add(b.config.OpenTimeout)) {
+		b.state = BreakerHalfOpen
+		b.halfOpenInFlight = 0
+		b.generation++
+	}
+	return b.state
+}
+
+// allow reports whether a call may go through and returns the generation
+// to record its outcome against
+func (b *CircuitBreaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return 0, false
+	case BreakerHalfOpen:
+		if b.halfOpenInFlight >= b.config.HalfOpenRequests {
+			return 0, false
+		}
+		b.halfOpenInFlight++
+	}
+	return b.generation, true
+}
+
+func (b *CircuitBreaker) record(ctx context.Context, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The state changed while the call was in flight; it was allowed under
+	// rules that no longer apply and holds no half-open slot
+	if generation != b.generation {
+		return
+	}
+
+	// A send cancelled or timed out by the caller says nothing about the provider either way
+	if err != nil && ctx.Err() != nil {
+		if b.state == BreakerHalfOpen {
+			b.halfOpenInFlight--
+		}
+		return
+	}
+
+	// Only provider-side trouble counts; a bad number says nothing about the provider
+	failed := err != nil && IsRetryable(err)
+
+	if b.state == BreakerHalfOpen {
+		b.halfOpenInFlight--
+		if failed {
+			b.trip()
+		} else {
+			b.reset()
+		}
+		return
+	}
+
+	b.window[b.windowNext] = !failed
+	b.windowNext = (b.windowNext + 1) % len(b.window)
+	if b.windowCount < len(b.window) {
+		b.windowCount++
+	}
+
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+
+	if b.state == BreakerClosed && b.shouldTrip() {
+		b.trip()
+	}
+}
+
+func (b *CircuitBreaker) shouldTrip() bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+
+	if b.config.FailureRate <= 0 || b.windowCount < b.config.MinRequests {
+		return false
+	}
+
+	return b.failureRate() >= b.config.FailureRate
+}
+
+// failureRate is the share of failures in the window. Must be called with mu held.
+func (b *CircuitBreaker) failureRate() float64 {
+	if b.windowCount == 0 {
+		return 0
+	}
+
+	failures := 0
+	for i := 0; i < b.windowCount; i++ {
+		if !b.window[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(b.windowCount)
+}
+
+func (b *CircuitBreaker) trip() {
+	b.state = BreakerOpen
+	b.openedAt = b.clock.Now()
+	b.halfOpenInFlight = 0
+	b.generation++
+}
+
+func (b *CircuitBreaker) reset() {
+	b.state = BreakerClosed
+	b.consecutive = 0
+	b.windowNext = 0
+	b.windowCount = 0
+	b.halfOpenInFlight = 0
+	b.generation++
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+)
+
+// scriptedClient returns err for every send and counts calls
+type scriptedClient struct {
+	err   error
+	calls int
+}
+
+func (c *scriptedClient) SendMessage(ctx context.Context, phoneNumber, content string) (*domain.WebhookResponse, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &domain.WebhookResponse{Message: "Accepted", MessageID: "id"}, nil
+}
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{err: &DeliveryError{Kind: ErrorKindServer, StatusCode: 503}}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		WindowSize:          10,
+		OpenTimeout:         30 * time.Second,
+	}, clock)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		breaker.SendMessage(ctx, "+905551111111", "hi")
+	}
+	if state := breaker.Snapshot().State; state != BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+
+	if _, err := breaker.SendMessage(ctx, "+905551111111", "hi"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if client.calls != 3 {
+		t.Errorf("calls = %d, want provider untouched while open", client.calls)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if state := breaker.Snapshot().State; state != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half_open", state)
+	}
+
+	client.err = nil
+	if _, err := breaker.SendMessage(ctx, "+905551111111", "hi"); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if state := breaker.Snapshot().State; state != BreakerClosed {
+		t.Errorf("state = %s, want closed after successful probe", state)
+	}
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{err: &DeliveryError{Kind: ErrorKindTimeout}}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		WindowSize:          10,
+		OpenTimeout:         time.Minute,
+	}, clock)
+	ctx := context.Background()
+
+	breaker.SendMessage(ctx, "+905551111111", "hi")
+	clock.now = clock.now.Add(time.Minute)
+	breaker.SendMessage(ctx, "+905551111111", "hi")
+
+	snapshot := breaker.Snapshot()
+	if snapshot.State != BreakerOpen {
+		t.Fatalf("state = %s, want open after failed probe", snapshot.State)
+	}
+	if !snapshot.OpenedAt.Equal(clock.now) {
+		t.Errorf("opened_at = %v, want reset to %v", snapshot.OpenedAt, clock.now)
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		FailureRate: 0.5,
+		WindowSize:  4,
+		MinRequests: 4,
+		OpenTimeout: time.Minute,
+	}, clock)
+	ctx := context.Background()
+
+	for _, fail := range []bool{false, true, false, true} {
+		client.err = nil
+		if fail {
+			client.err = &DeliveryError{Kind: ErrorKindNetwork}
+		}
+		breaker.SendMessage(ctx, "+905551111111", "hi")
+	}
+
+	if state := breaker.Snapshot().State; state != BreakerOpen {
+		t.Errorf("state = %s, want open at 50%% failures", state)
+	}
+}
+
+func TestCircuitBreaker_IgnoresNonRetryableErrors(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{err: &DeliveryError{Kind: ErrorKindValidation, StatusCode: 400}}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		WindowSize:          10,
+		OpenTimeout:         time.Minute,
+	}, clock)
+
+	for i := 0; i < 5; i++ {
+		breaker.SendMessage(context.Background(), "+905551111111", "hi")
+	}
+
+	if state := breaker.Snapshot().State; state != BreakerClosed {
+		t.Errorf("state = %s, want closed for validation errors", state)
+	}
+}
+
+func TestCircuitBreaker_IgnoresCallsFromEarlierState(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{err: &DeliveryError{Kind: ErrorKindServer, StatusCode: 503}}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		WindowSize:          10,
+		OpenTimeout:         time.Minute,
+	}, clock)
+	ctx := context.Background()
+
+	// A slow call starts while closed and outlives the trip
+	slow, ok := breaker.allow()
+	if !ok {
+		t.Fatal("closed breaker refused a call")
+	}
+	breaker.SendMessage(ctx, "+905551111111", "hi")
+	clock.now = clock.now.Add(time.Minute)
+
+	probe, ok := breaker.allow()
+	if !ok {
+		t.Fatal("half-open breaker refused the probe")
+	}
+
+	// Its failure neither reopens the circuit nor frees the probe slot
+	breaker.record(ctx, slow, client.err)
+	if state := breaker.Snapshot().State; state != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half_open after a stale failure", state)
+	}
+	if _, ok := breaker.allow(); ok {
+		t.Error("second probe allowed while the first is in flight")
+	}
+
+	breaker.record(ctx, probe, nil)
+	if state := breaker.Snapshot().State; state != BreakerClosed {
+		t.Errorf("state = %s, want closed after the probe succeeded", state)
+	}
+}
+
+func TestCircuitBreaker_IgnoresCallerDeadline(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := &scriptedClient{err: &DeliveryError{Kind: ErrorKindTimeout, Err: context.DeadlineExceeded}}
+	breaker := NewCircuitBreaker(client, CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		WindowSize:          10,
+		OpenTimeout:         time.Minute,
+	}, clock)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		breaker.SendMessage(ctx, "+905551111111", "hi")
+	}
+
+	if snapshot := breaker.Snapshot(); snapshot.State != BreakerClosed || snapshot.Requests != 0 {
+		t.Errorf("snapshot = %+v, want the caller's deadline not counted", snapshot)
+	}
+}
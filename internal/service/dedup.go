@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DuplicateStore keeps which message holds each recipient and content key;
+// *redis.Client implements it. A message repeats another when both have the
+// same recipient and content within Options.DedupWindow. Each message claims
+// the key of its recipient and content hash under its own id when it is
+// created and again right before it is sent, so it only ever collides with
+// other messages. Without a store, or while it fails, nothing is suppressed.
+type DuplicateStore interface {
+	// ClaimDedupKeys claims each key for the owner at the same index, in
+	// order, for ttl and returns who holds each key afterwards
+	ClaimDedupKeys(ctx context.Context, keys, owners []string, ttl time.Duration) ([]string, error)
+	// ReleaseDedupKeys frees each key its owner at the same index still holds
+	ReleaseDedupKeys(ctx context.Context, keys, owners []string) error
+}
+
+// dedupClaim is a key claimed by a new message, kept to undo the claim when
+// the message is not created after all
+type dedupClaim struct {
+	key   string
+	owner string
+}
+
+func (s *messageService) dedupEnabled() bool {
+	return s.duplicates != nil && s.opts.DedupWindow > 0
+}
+
+// recipientContentKey identifies the recipient and content of a message
+func recipientContentKey(message *domain.Message) string {
+	sum := sha256.Sum256([]byte(message.Content))
+	return message.PhoneNumber + ":" + hex.EncodeToString(sum[:])
+}
+
+func (s *messageService) suppressionReason(holder string) string {
+	return fmt.Sprintf("duplicate of message %s to the same recipient within %s", holder, s.opts.DedupWindow)
+}
+
+// duplicatesOf claims the key of each message and returns, for each, the id
+// of the other message holding it, or "" when the message got its key
+func (s *messageService) duplicatesOf(ctx context.Context, messages []*domain.Message) []string {
+	duplicates := make([]string, len(messages))
+	if !s.dedupEnabled() || len(messages) == 0 {
+		return duplicates
+	}
+
+	keys := make([]string, len(messages))
+	owners := make([]string, len(messages))
+	for i, message := range messages {
+		keys[i] = recipientContentKey(message)
+		owners[i] = message.ID.Hex()
+	}
+
+	holders, err := s.duplicates.ClaimDedupKeys(ctx, keys, owners, s.opts.DedupWindow)
+	if err != nil {
+		s.logger.Error("Failed to check for duplicate messages: %v", err)
+		return duplicates
+	}
+
+	for i, holder := range holders {
+		if holder != "" && holder != owners[i] {
+			duplicates[i] = holder
+		}
+	}
+	return duplicates
+}
+
+// suppressDuplicates marks new messages that repeat a recent message as
+// suppressed; they are still stored so the caller can see why. It returns
+// the claim of each message that was not suppressed.
+func (s *messageService) suppressDuplicates(ctx context.Context, messages []*domain.Message) []dedupClaim {
+	claims := make([]dedupClaim, len(messages))
+	if !s.dedupEnabled() {
+		return claims
+	}
+
+	// The key is claimed under the id before the message is stored
+	for _, message := range messages {
+		if message.ID.IsZero() {
+			message.ID = primitive.NewObjectID()
+		}
+	}
+
+	for i, holder := range s.duplicatesOf(ctx, messages) {
+		message := messages[i]
+		if holder == "" {
+			claims[i] = dedupClaim{key: recipientContentKey(message), owner: message.ID.Hex()}
+			continue
+		}
+
+		if err := message.MarkAsSuppressed(s.suppressionReason(holder)); err != nil {
+			s.logger.Error("Message to %s: %v", message.PhoneNumber, err)
+			continue
+		}
+		event := message.Event(domain.StatusPending, domain.ActorAPI)
+		event.Error = message.SuppressionReason
+		message.Events = append(message.Events, event)
+	}
+
+	return claims
+}
+
+// suppressDuplicate suppresses a claimed message instead of sending it when
+// it repeats a message created or sent within the window. It reports whether
+// the message was suppressed.
+func (s *messageService) suppressDuplicate(ctx context.Context, msg *domain.Message) bool {
+	holder := s.duplicatesOf(ctx, []*domain.Message{msg})[0]
+	if holder == "" {
+		return false
+	}
+
+	from := msg.Status
+	if err := msg.MarkAsSuppressed(s.suppressionReason(holder)); err != nil {
+		s.logger.Error("Message ID %s: %v", msg.ID.Hex(), err)
+		return false
+	}
+	s.logger.Info("Message ID %s suppressed as a duplicate of %s", msg.ID.Hex(), holder)
+
+	event := msg.Event(from, s.actor())
+	event.Error = msg.SuppressionReason
+	if err := s.repo.UpdateMessageStatus(ctx, msg, event); err != nil {
+		s.logger.Error("Failed to mark message as suppressed: %v", err)
+	}
+	return true
+}
+
+// releaseDedupClaims frees the given claims so that new copies of their
+// messages are not suppressed
+func (s *messageService) releaseDedupClaims(ctx context.Context, claims []dedupClaim) {
+	if !s.dedupEnabled() || len(claims) == 0 {
+		return
+	}
+
+	keys := make([]string, len(claims))
+	owners := make([]string, len(claims))
+	for i, claim := range claims {
+		keys[i] = claim.key
+		owners[i] = claim.owner
+	}
+
+	if err := s.duplicates.ReleaseDedupKeys(ctx, keys, owners); err != nil {
+		s.logger.Error("Failed to release duplicate checks: %v", err)
+	}
+}
+
+// releaseDedupClaim frees the key of a message that will not be sent, so
+// that sending it again is not suppressed
+func (s *messageService) releaseDedupClaim(ctx context.Context, msg *domain.Message) {
+	s.releaseDedupClaims(ctx, []dedupClaim{{key: recipientContentKey(msg), owner: msg.ID.Hex()}})
+}
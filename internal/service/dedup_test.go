@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+// memoryDuplicates holds dedup keys in memory the way the Redis scripts do,
+// without a TTL
+type memoryDuplicates struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func newMemoryDuplicates() *memoryDuplicates {
+	return &memoryDuplicates{holders: make(map[string]string)}
+}
+
+func (d *memoryDuplicates) ClaimDedupKeys(ctx context.Context, keys, owners []string, ttl time.Duration) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	holders := make([]string, len(keys))
+	for i, key := range keys {
+		if _, held := d.holders[key]; !held {
+			d.holders[key] = owners[i]
+		}
+		holders[i] = d.holders[key]
+	}
+	return holders, nil
+}
+
+func (d *memoryDuplicates) ReleaseDedupKeys(ctx context.Context, keys, owners []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, key := range keys {
+		if d.holders[key] == owners[i] {
+			delete(d.holders, key)
+		}
+	}
+	return nil
+}
+
+// holder returns the id of the message holding the key of message
+func (d *memoryDuplicates) holder(message *domain.Message) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.holders[recipientContentKey(message)]
+}
+
+func dedupOptions(store DuplicateStore) Options {
+	return Options{DedupWindow: 5 * time.Minute, Duplicates: store, Concurrency: 1, MaxAttempts: 1}
+}
+
+func TestCreateMessages_SuppressesDuplicates(t *testing.T) {
+	store := newMemoryDuplicates()
+	repo := &insertRepository{}
+	svc := NewMessageService(repo, nil, nil, logger.New(), dedupOptions(store))
+
+	first := []*domain.Message{
+		{PhoneNumber: "+905551111111", Content: "hello"},
+		{PhoneNumber: "+905551111111", Content: "hello"},
+		{PhoneNumber: "+905552222222", Content: "hello"},
+	}
+	for i, result := range svc.CreateMessages(context.Background(), first) {
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+	}
+
+	if first[0].Status != domain.StatusPending || first[2].Status != domain.StatusPending {
+		t.Errorf("statuses = %s, %s, want the first copy and the other recipient pending", first[0].Status, first[2].Status)
+	}
+	// The repeat in the same batch is stored as suppressed, naming the first
+	dup := first[1]
+	if dup.Status != domain.StatusSuppressed || !strings.Contains(dup.SuppressionReason, first[0].ID.Hex()) {
+		t.Errorf("same batch repeat = %s %q, want suppressed as a duplicate of %s", dup.Status, dup.SuppressionReason, first[0].ID.Hex())
+	}
+	if last := dup.Events[len(dup.Events)-1]; last.To != domain.StatusSuppressed || last.Error != dup.SuppressionReason {
+		t.Errorf("event = %+v, want the suppression with its reason", last)
+	}
+
+	second := []*domain.Message{{PhoneNumber: "+905551111111", Content: "hello"}}
+	if result := svc.CreateMessages(context.Background(), second)[0]; result.Err != nil {
+		t.Fatalf("second batch: %v", result.Err)
+	}
+	if second[0].Status != domain.StatusSuppressed || !strings.Contains(second[0].SuppressionReason, first[0].ID.Hex()) {
+		t.Errorf("next batch repeat = %s %q, want suppressed as a duplicate of %s", second[0].Status, second[0].SuppressionReason, first[0].ID.Hex())
+	}
+
+	if got := store.holder(first[0]); got != first[0].ID.Hex() {
+		t.Errorf("key held by %q, want the first message", got)
+	}
+}
+
+func TestCreateMessages_ReleasesClaimsOfUncreatedMessages(t *testing.T) {
+	t.Run("failed insert", func(t *testing.T) {
+		store := newMemoryDuplicates()
+		repo := &insertRepository{reject: map[string]bool{"taken": true}}
+		svc := NewMessageService(repo, nil, nil, logger.New(), dedupOptions(store))
+
+		msg := &domain.Message{PhoneNumber: "+905551111111", Content: "taken"}
+		if result := svc.CreateMessages(context.Background(), []*domain.Message{msg})[0]; result.Err == nil {
+			t.Fatal("insert succeeded, want it rejected")
+		}
+		if got := store.holder(msg); got != "" {
+			t.Errorf("key held by %q after the insert failed", got)
+		}
+	})
+
+	t.Run("replayed idempotency key", func(t *testing.T) {
+		original := &domain.Message{
+			ID:          primitive.NewObjectID(),
+			PhoneNumber: "+905551111111",
+			Content:     "hello",
+			ExternalID:  "order-1",
+			Status:      domain.StatusSent,
+			CreatedAt:   time.Now().Add(-time.Hour),
+		}
+		store := newMemoryDuplicates()
+		repo := &keyedRepository{byKey: map[string]*domain.Message{"order-1": original}}
+		opts := dedupOptions(store)
+		opts.IdempotencyWindow = 24 * time.Hour
+		svc := NewMessageService(repo, nil, nil, logger.New(), opts)
+
+		msg := &domain.Message{PhoneNumber: "+905551111111", Content: "hello", ExternalID: "order-1"}
+		if result := svc.CreateMessages(context.Background(), []*domain.Message{msg})[0]; !result.Replayed {
+			t.Fatalf("result = %+v, want replayed", result)
+		}
+		if got := store.holder(msg); got != "" {
+			t.Errorf("key held by %q after the replay", got)
+		}
+	})
+}
+
+// cancelRepository cancels any message it is asked to
+type cancelRepository struct {
+	repository.MessageRepository
+
+	message *domain.Message
+}
+
+func (r *cancelRepository) CancelMessage(ctx context.Context, id primitive.ObjectID, actor string) (*domain.Message, error) {
+	r.message.Status = domain.StatusCancelled
+	return r.message, nil
+}
+
+func TestCancelMessage_ReleasesDedupKey(t *testing.T) {
+	msg := &domain.Message{ID: primitive.NewObjectID(), PhoneNumber: "+905551111111", Content: "hello", Status: domain.StatusPending}
+	store := newMemoryDuplicates()
+	store.ClaimDedupKeys(context.Background(), []string{recipientContentKey(msg)}, []string{msg.ID.Hex()}, time.Minute)
+
+	svc := NewMessageService(&cancelRepository{message: msg}, nil, nil, logger.New(), dedupOptions(store))
+	if _, err := svc.CancelMessage(context.Background(), msg.ID); err != nil {
+		t.Fatalf("CancelMessage: %v", err)
+	}
+	if got := store.holder(msg); got != "" {
+		t.Errorf("key held by %q after the cancel", got)
+	}
+}
+
+func TestProcessPendingMessages_DedupKeys(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	claimed := func(phone, content string) *domain.Message {
+		return &domain.Message{ID: primitive.NewObjectID(), PhoneNumber: phone, Content: content, Status: domain.StatusProcessing}
+	}
+	duplicate := claimed("+905551111111", "hello")
+	expired := claimed("+905552222222", "late")
+	expired.ExpiresAt = &past
+	failing := claimed("+905553333333", "boom")
+	sent := claimed("+905554444444", "ok")
+
+	// Each message claimed its key when it was created; the duplicate lost it
+	store := newMemoryDuplicates()
+	store.ClaimDedupKeys(context.Background(),
+		[]string{recipientContentKey(duplicate), recipientContentKey(expired), recipientContentKey(failing)},
+		[]string{"other", expired.ID.Hex(), failing.ID.Hex()},
+		time.Minute)
+
+	repo := &batchRepository{batch: []*domain.Message{duplicate, expired, failing, sent}}
+	client := &recordingClient{errs: map[string]error{"boom": errors.New("boom")}}
+	svc := NewMessageService(repo, client, nil, logger.New(), dedupOptions(store))
+
+	result, err := svc.ProcessPendingMessages(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("ProcessPendingMessages: %v", err)
+	}
+	if result.Suppressed != 1 || result.Sent != 1 {
+		t.Errorf("result = %+v, want 1 suppressed and 1 sent", result)
+	}
+	if duplicate.Status != domain.StatusSuppressed || len(client.sent["+905551111111"]) != 0 {
+		t.Errorf("duplicate = %s, want suppressed without sending", duplicate.Status)
+	}
+	if expired.Status != domain.StatusExpired || failing.Status != domain.StatusDeadLetter {
+		t.Errorf("statuses = %s, %s, want expired and dead-lettered", expired.Status, failing.Status)
+	}
+
+	// Messages that will not be sent free their key; the others keep theirs
+	for _, msg := range []*domain.Message{expired, failing} {
+		if got := store.holder(msg); got != "" {
+			t.Errorf("%s message key held by %q", msg.Status, got)
+		}
+	}
+	if got := store.holder(duplicate); got != "other" {
+		t.Errorf("duplicate key held by %q, want the original", got)
+	}
+	if got := store.holder(sent); got != sent.ID.Hex() {
+		t.Errorf("sent message key held by %q, want the message", got)
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+)
+
+// outcome is what happened to a single message of a batch
+type outcome int
+
+const (
+	outcomeSent outcome = iota
+	outcomeFailed
+	outcomeDeadLettered
+	outcomeExpired
+	outcomeSuppressed
+	// outcomeHalt means the message was not attempted and the batch must stop
+	outcomeHalt
+)
+
+// dispatch sends a claimed batch with up to Options.Concurrency workers.
+// Messages for the same recipient form one lane that a single worker sends in
+// claim order, so a recipient never receives two messages out of order. When
+// the batch stops early (cancellation, open circuit, fatal error) every
+// message not yet attempted is released back to pending.
+func (s *messageService) dispatch(ctx context.Context, messages []*domain.Message, result *BatchResult) error {
+	lanes := groupByRecipient(messages)
+
+	workers := s.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(lanes) {
+		workers = len(lanes)
+	}
+
+	var (
+		mu       sync.Mutex
+		halted   bool
+		haltErr  error
+		fatalErr error
+		wg       sync.WaitGroup
+	)
+
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return halted
+	}
+
+	release := func(rest []*domain.Message) {
+		s.releaseClaims(rest)
+
+		mu.Lock()
+		defer mu.Unlock()
+		result.Deferred += len(rest)
+		if haltErr == nil {
+			haltErr = ctx.Err()
+		}
+	}
+
+	laneChan := make(chan []*domain.Message)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for lane := range laneChan {
+				for j, msg := range lane {
+					if ctx.Err() != nil || stopped() {
+						release(lane[j:])
+						break
+					}
+
+					out, err := s.processMessage(ctx, msg)
+
+					mu.Lock()
+					switch out {
+					case outcomeSent:
+						result.Sent++
+					case outcomeFailed:
+						result.Failed++
+					case outcomeDeadLettered:
+						result.Failed++
+						result.DeadLettered++
+					case outcomeExpired:
+						result.Expired++
+					case outcomeSuppressed:
+						result.Suppressed++
+					case outcomeHalt:
+						halted = true
+						if IsFatal(err) && fatalErr == nil {
+							fatalErr = err
+						}
+					}
+					mu.Unlock()
+
+					if out == outcomeHalt {
+						release(lane[j:])
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	for _, lane := range lanes {
+		laneChan <- lane
+	}
+	close(laneChan)
+	wg.Wait()
+
+	if fatalErr != nil {
+		return fmt.Errorf("fatal delivery error: %w", fatalErr)
+	}
+	return haltErr
+}
+
+// processMessage expires, suppresses, sends or records the failure of one message
+func (s *messageService) processMessage(ctx context.Context, msg *domain.Message) (outcome, error) {
+	if msg.IsExpired(time.Now()) {
+		s.expireMessage(ctx, msg)
+		return outcomeExpired, nil
+	}
+
+	if s.suppressDuplicate(ctx, msg) {
+		return outcomeSuppressed, nil
+	}
+
+	err := s.sendMessage(ctx, msg)
+	if err == nil {
+		s.logger.Info("Successfully sent message ID %s to %s", msg.ID.Hex(), msg.PhoneNumber)
+		return outcomeSent, nil
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		// Cancelled mid-send, e.g. while waiting on the rate limiter: not the message's fault
+		return outcomeHalt, ctx.Err()
+	case errors.Is(err, ErrCircuitOpen):
+		// The provider is down: keep the rest of the batch pending instead of failing it
+		s.logger.Info("Circuit breaker open, returning unsent messages to pending")
+		return outcomeHalt, err
+	case IsFatal(err):
+		// Not this message's fault: hand it and the rest of the batch back untouched
+		s.logger.Error("ALERT: fatal webhook error, stopping batch: %v", err)
+		return outcomeHalt, err
+	}
+
+	s.logger.Error("Failed to send message ID %s: %v", msg.ID.Hex(), err)
+	if s.handleSendFailure(msg, err) {
+		return outcomeDeadLettered, err
+	}
+	return outcomeFailed, err
+}
+
+// groupByRecipient splits messages into per-recipient lanes, keeping claim
+// order both between lanes and within each lane
+func groupByRecipient(messages []*domain.Message) [][]*domain.Message {
+	index := make(map[string]int)
+	var lanes [][]*domain.Message
+
+	for _, msg := range messages {
+		i, ok := index[msg.PhoneNumber]
+		if !ok {
+			i = len(lanes)
+			index[msg.PhoneNumber] = i
+			lanes = append(lanes, nil)
+		}
+		lanes[i] = append(lanes[i], msg)
+	}
+
+	return lanes
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+// batchRepository hands out a fixed batch and records what happened to it
+type batchRepository struct {
+	repository.MessageRepository
+
+	mu       sync.Mutex
+	batch    []*domain.Message
+	updated  []*domain.Message
+	events   []domain.StatusEvent
+	released []primitive.ObjectID
+}
+
+func (r *batchRepository) ClaimPendingMessages(ctx context.Context, opts repository.ClaimOptions) ([]*domain.Message, error) {
+	return r.batch, nil
+}
+
+func (r *batchRepository) UpdateMessageStatus(ctx context.Context, message *domain.Message, event domain.StatusEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updated = append(r.updated, message)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *batchRepository) ReleaseClaims(ctx context.Context, owner string, ids []primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.released = append(r.released, ids...)
+	return nil
+}
+
+// recordingClient records the order of contents per recipient and fails
+// with errs[content] when set
+type recordingClient struct {
+	delay time.Duration
+	errs  map[string]error
+
+	mu       sync.Mutex
+	sent     map[string][]string
+	inFlight int
+	peak     int
+}
+
+func (c *recordingClient) SendMessage(ctx context.Context, phoneNumber, content string) (*domain.WebhookResponse, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.peak {
+		c.peak = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if err := c.errs[content]; err != nil {
+		return nil, err
+	}
+	if c.sent == nil {
+		c.sent = make(map[string][]string)
+	}
+	c.sent[phoneNumber] = append(c.sent[phoneNumber], content)
+	return &domain.WebhookResponse{Message: "Accepted", MessageID: content}, nil
+}
+
+func newBatch(recipients []string, perRecipient int) []*domain.Message {
+	var batch []*domain.Message
+	for i := 0; i < perRecipient; i++ {
+		for _, phone := range recipients {
+			batch = append(batch, &domain.Message{
+				ID:          primitive.NewObjectID(),
+				PhoneNumber: phone,
+				Content:     fmt.Sprintf("%s-%d", phone, i),
+				Status:      domain.StatusProcessing,
+			})
+		}
+	}
+	return batch
+}
+
+func TestProcessPendingMessages_ConcurrentInOrderPerRecipient(t *testing.T) {
+	recipients := []string{"+905551111111", "+905552222222", "+905553333333", "+905554444444"}
+	repo := &batchRepository{batch: newBatch(recipients, 3)}
+	client := &recordingClient{delay: 10 * time.Millisecond}
+	svc := NewMessageService(repo, client, nil, logger.New(), Options{Concurrency: 4, MaxAttempts: 3})
+
+	result, err := svc.ProcessPendingMessages(context.Background(), 12)
+	if err != nil {
+		t.Fatalf("ProcessPendingMessages: %v", err)
+	}
+	if result.Sent != 12 {
+		t.Errorf("sent = %d, want 12", result.Sent)
+	}
+	if client.peak < 2 || client.peak > 4 {
+		t.Errorf("peak concurrency = %d, want between 2 and 4", client.peak)
+	}
+
+	for _, phone := range recipients {
+		got := client.sent[phone]
+		for i, content := range got {
+			if want := fmt.Sprintf("%s-%d", phone, i); content != want {
+				t.Errorf("%s message %d = %s, want %s", phone, i, content, want)
+			}
+		}
+	}
+}
+
+func TestProcessPendingMessages_FatalReleasesUnsent(t *testing.T) {
+	repo := &batchRepository{batch: newBatch([]string{"+905551111111"}, 3)}
+	client := &recordingClient{errs: map[string]error{
+		"+905551111111-1": &DeliveryError{Kind: ErrorKindAuth, StatusCode: 401},
+	}}
+	svc := NewMessageService(repo, client, nil, logger.New(), Options{Concurrency: 2, MaxAttempts: 3})
+
+	result, err := svc.ProcessPendingMessages(context.Background(), 3)
+	if !IsFatal(err) {
+		t.Fatalf("err = %v, want fatal", err)
+	}
+	if result.Sent != 1 || result.Deferred != 2 || result.Failed != 0 {
+		t.Errorf("result = %+v, want 1 sent and 2 deferred", result)
+	}
+	if len(repo.released) != 2 {
+		t.Errorf("released = %d, want 2", len(repo.released))
+	}
+}
+
+func TestProcessPendingMessages_CancelledReleasesAll(t *testing.T) {
+	repo := &batchRepository{batch: newBatch([]string{"+905551111111", "+905552222222"}, 2)}
+	svc := NewMessageService(repo, &recordingClient{}, nil, logger.New(), Options{Concurrency: 2, MaxAttempts: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := svc.ProcessPendingMessages(ctx, 4)
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if result.Deferred != 4 || len(repo.released) != 4 {
+		t.Errorf("result = %+v released = %d, want all 4 deferred", result, len(repo.released))
+	}
+}
+
+func TestProcessPendingMessages_RecordsEvents(t *testing.T) {
+	repo := &batchRepository{batch: newBatch([]string{"+905551111111", "+905552222222"}, 1)}
+	client := &recordingClient{errs: map[string]error{
+		"+905552222222-0": &DeliveryError{Kind: ErrorKindServer, StatusCode: 503, Body: "busy"},
+	}}
+	svc := NewMessageService(repo, client, nil, logger.New(), Options{
+		InstanceID:     "worker-1",
+		Concurrency:    1,
+		MaxAttempts:    3,
+		RetryBaseDelay: time.Minute,
+		RetryMaxDelay:  time.Hour,
+	})
+
+	if _, err := svc.ProcessPendingMessages(context.Background(), 2); err != nil {
+		t.Fatalf("ProcessPendingMessages: %v", err)
+	}
+	if len(repo.events) != 2 {
+		t.Fatalf("events = %d, want 2", len(repo.events))
+	}
+
+	sent, retried := repo.events[0], repo.events[1]
+	if sent.From != domain.StatusProcessing || sent.To != domain.StatusSent || sent.Attempt != 1 || sent.Actor != "scheduler:worker-1" {
+		t.Errorf("sent event = %+v", sent)
+	}
+	if retried.To != domain.StatusPending || retried.Attempt != 1 || retried.ProviderResponse != "HTTP 503: busy" || retried.Error == "" {
+		t.Errorf("retry event = %+v", retried)
+	}
+}
+
+func TestProcessPendingMessages_RetryAfterDelaysNextAttempt(t *testing.T) {
+	repo := &batchRepository{batch: newBatch([]string{"+905551111111"}, 1)}
+	client := &recordingClient{errs: map[string]error{
+		"+905551111111-0": &DeliveryError{Kind: ErrorKindRateLimited, StatusCode: 429, RetryAfter: time.Hour},
+	}}
+	svc := NewMessageService(repo, client, nil, logger.New(), Options{
+		Concurrency:    1,
+		MaxAttempts:    3,
+		RetryBaseDelay: time.Second,
+		RetryMaxDelay:  time.Minute,
+	})
+
+	before := time.Now()
+	if _, err := svc.ProcessPendingMessages(context.Background(), 1); err != nil {
+		t.Fatalf("ProcessPendingMessages: %v", err)
+	}
+
+	msg := repo.batch[0]
+	if msg.Status != domain.StatusPending || msg.NextAttemptAt == nil || msg.NextAttemptAt.Before(before.Add(time.Hour)) {
+		t.Errorf("next attempt = %v, want after the hour the provider asked for", msg.NextAttemptAt)
+	}
+}
+
+// flakyRepository fails the first failures status writes
+type flakyRepository struct {
+	batchRepository
+
+	failures int
+	writes   int
+}
+
+func (r *flakyRepository) UpdateMessageStatus(ctx context.Context, message *domain.Message, event domain.StatusEvent) error {
+	r.writes++
+	if r.writes <= r.failures {
+		return errors.New("connection reset")
+	}
+	return r.batchRepository.UpdateMessageStatus(ctx, message, event)
+}
+
+func TestProcessPendingMessages_RetriesStoringSentStatus(t *testing.T) {
+	repo := &flakyRepository{batchRepository: batchRepository{batch: newBatch([]string{"+905551111111"}, 1)}, failures: 2}
+	client := &recordingClient{}
+	svc := NewMessageService(repo, client, nil, logger.New(), Options{Concurrency: 1, MaxAttempts: 3})
+
+	result, err := svc.ProcessPendingMessages(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("ProcessPendingMessages: %v", err)
+	}
+	if result.Sent != 1 || repo.writes != 3 {
+		t.Errorf("result = %+v after %d writes, want sent on the third", result, repo.writes)
+	}
+	if len(repo.events) != 1 || repo.events[0].To != domain.StatusSent {
+		t.Errorf("events = %+v, want only the sent status stored", repo.events)
+	}
+	if len(client.sent["+905551111111"]) != 1 {
+		t.Errorf("sent %d times, want once", len(client.sent["+905551111111"]))
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorKind classifies why a message could not be delivered
+type ErrorKind string
+
+const (
+	ErrorKindNetwork     ErrorKind = "network"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindRateLimited ErrorKind = "rate_limited"
+	ErrorKindServer      ErrorKind = "server"
+	ErrorKindValidation  ErrorKind = "validation"
+	ErrorKindAuth        ErrorKind = "auth"
+	ErrorKindBadResponse ErrorKind = "bad_response"
+)
+
+// DeliveryError is a classified failure to deliver a message to the provider
+type DeliveryError struct {
+	Kind       ErrorKind
+	StatusCode int
+	Body       string
+	// RetryAfter is the wait the provider asked for on 429 and 503 responses
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := string(e.Kind)
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%s (status %d)", msg, e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Body != "" {
+		msg += ", body: " + e.Body
+	}
+	return msg
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether sending the same request again may succeed
+func (e *DeliveryError) Retryable() bool {
+	switch e.Kind {
+	case ErrorKindNetwork, ErrorKindTimeout, ErrorKindRateLimited, ErrorKindServer:
+		return true
+	}
+	return false
+}
+
+// Fatal reports whether the failure affects every message, not just this one
+func (e *DeliveryError) Fatal() bool {
+	return e.Kind == ErrorKindAuth
+}
+
+// IsRetryable reports whether err is worth retrying. Unclassified errors are
+// assumed to be transient.
+func IsRetryable(err error) bool {
+	if deliveryErr, ok := asDeliveryError(err); ok {
+		return deliveryErr.Retryable()
+	}
+	return true
+}
+
+// IsFatal reports whether err should stop all sending until an operator intervenes
+func IsFatal(err error) bool {
+	deliveryErr, ok := asDeliveryError(err)
+	return ok && deliveryErr.Fatal()
+}
+
+func asDeliveryError(err error) (*DeliveryError, bool) {
+	var deliveryErr *DeliveryError
+	ok := errors.As(err, &deliveryErr)
+	return deliveryErr, ok
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *messageService) idempotencyWindow() time.Duration {
+	if s.opts.IdempotencyWindow > 0 {
+		return s.opts.IdempotencyWindow
+	}
+	return defaultIdempotencyWindow
+}
+
+// replayable reports whether a repeated key still refers to original
+func (s *messageService) replayable(original *domain.Message, now time.Time) bool {
+	return now.Sub(original.CreatedAt) < s.idempotencyWindow()
+}
+
+// replay answers a repeated key with the original message, provided the
+// request is the same one sent again
+func replay(message, original *domain.Message) CreateResult {
+	if message.PhoneNumber != original.PhoneNumber || message.Content != original.Content {
+		return CreateResult{Err: domain.ErrIdempotencyConflict}
+	}
+
+	*message = *original
+	return CreateResult{Replayed: true}
+}
+
+// cachedOriginals returns, for each message, the replayable original Redis
+// remembers for its key, or nil. Redis errors count as misses.
+func (s *messageService) cachedOriginals(ctx context.Context, messages []*domain.Message, now time.Time) []*domain.Message {
+	originals := make([]*domain.Message, len(messages))
+	if s.redisClient == nil {
+		return originals
+	}
+
+	var keys []string
+	var indexes []int
+	for i, message := range messages {
+		if message.ExternalID != "" {
+			keys = append(keys, message.ExternalID)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(keys) == 0 {
+		return originals
+	}
+
+	ids, err := s.redisClient.LookupIdempotencyKeys(ctx, keys)
+	if err != nil {
+		s.logger.Error("Failed to look up idempotency keys: %v", err)
+		return originals
+	}
+
+	for i, rawID := range ids {
+		id, err := primitive.ObjectIDFromHex(rawID)
+		if err != nil {
+			continue
+		}
+
+		original, err := s.repo.GetMessageByID(ctx, id)
+		if err != nil || original.ExternalID != keys[i] || !s.replayable(original, now) {
+			continue
+		}
+		originals[indexes[i]] = original
+	}
+
+	return originals
+}
+
+// resolveDuplicate handles a message whose key another message already holds.
+// Within the window the original is replayed; an older message gives up the
+// key and the new one is created.
+func (s *messageService) resolveDuplicate(ctx context.Context, message *domain.Message, now time.Time) CreateResult {
+	original, err := s.repo.GetMessageByExternalID(ctx, message.ExternalID)
+	if err != nil && !errors.Is(err, repository.ErrMessageNotFound) {
+		return CreateResult{Err: fmt.Errorf("failed to create message: %w", err)}
+	}
+
+	if original != nil {
+		if s.replayable(original, now) {
+			return replay(message, original)
+		}
+
+		if err := s.repo.ReleaseExternalID(ctx, message.ExternalID, now.Add(-s.idempotencyWindow())); err != nil {
+			return CreateResult{Err: fmt.Errorf("failed to create message: %w", err)}
+		}
+	}
+
+	if err := s.repo.CreateMessage(ctx, message); err != nil {
+		return CreateResult{Err: fmt.Errorf("failed to create message: %w", err)}
+	}
+	return CreateResult{}
+}
+
+// rememberKeys caches the keys of the messages just created
+func (s *messageService) rememberKeys(ctx context.Context, messages []*domain.Message, results []CreateResult) {
+	if s.redisClient == nil {
+		return
+	}
+
+	ids := map[string]string{}
+	for i, message := range messages {
+		if message.ExternalID != "" && results[i].Err == nil && !results[i].Replayed {
+			ids[message.ExternalID] = message.ID.Hex()
+		}
+	}
+
+	if err := s.redisClient.RememberIdempotencyKeys(ctx, ids, s.idempotencyWindow()); err != nil {
+		s.logger.Error("Failed to cache idempotency keys: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ImportService interface {
+	Import(ctx context.Context, r io.Reader, fileName string, mapping domain.ImportMapping) (*domain.Import, error)
+	GetImport(ctx context.Context, id primitive.ObjectID) (*domain.Import, error)
+	EachRowError(ctx context.Context, id primitive.ObjectID, fn func(domain.ImportRowError) error) error
+}
+
+type importService struct {
+	messages  MessageService
+	imports   repository.ImportRepository
+	logger    *logger.Logger
+	chunkSize int
+}
+
+// NewImportService creates an import service that hands rows to messages in
+// chunks of chunkSize, so only one chunk of a file is held in memory
+func NewImportService(messages MessageService, imports repository.ImportRepository, logger *logger.Logger, chunkSize int) ImportService {
+	if chunkSize < 1 {
+		chunkSize = defaultInsertChunkSize
+	}
+	return &importService{
+		messages:  messages,
+		imports:   imports,
+		logger:    logger,
+		chunkSize: chunkSize,
+	}
+}
+
+// importRun is the state of one import while its rows are read
+type importRun struct {
+	imp      *domain.Import
+	messages []*domain.Message
+	rows     []domain.ImportRowError
+	rejected []domain.ImportRowError
+}
+
+// Import reads a CSV file with a header row and creates a message per row.
+// Rows that cannot be parsed or fail validation are rejected and kept for
+// the error report; they do not stop the import. It returns an error only
+// when the header is unusable, and marks the import aborted when reading
+// the file fails part way.
+func (s *importService) Import(ctx context.Context, r io.Reader, fileName string, mapping domain.ImportMapping) (*domain.Import, error) {
+	reader := csv.NewReader(r)
+	// Short rows read their missing columns as empty
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", domain.ErrInvalidCSV)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidCSV, err)
+	}
+
+	columns, err := mapping.Bind(header)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &importRun{imp: &domain.Import{FileName: fileName, Columns: header}}
+	if err := s.imports.CreateImport(ctx, run.imp); err != nil {
+		return nil, err
+	}
+
+	run.imp.Status = domain.ImportCompleted
+	for {
+		if err := ctx.Err(); err != nil {
+			run.abort(err)
+			break
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			run.imp.Rows++
+			run.reject(parseErr.StartLine, record, err)
+			continue
+		}
+		if err != nil {
+			run.abort(err)
+			break
+		}
+
+		run.imp.Rows++
+		line, _ := reader.FieldPos(0)
+
+		message, err := columns.Message(record)
+		if err != nil {
+			run.reject(line, record, err)
+		} else {
+			run.messages = append(run.messages, message)
+			run.rows = append(run.rows, domain.ImportRowError{Line: line, Values: record})
+		}
+
+		if len(run.messages) >= s.chunkSize {
+			s.createChunk(ctx, run)
+		}
+		if len(run.rejected) >= s.chunkSize {
+			if err := s.imports.AddRowErrors(ctx, run.rejected); err != nil {
+				run.abort(err)
+				break
+			}
+			run.rejected = run.rejected[:0]
+		}
+	}
+
+	s.createChunk(ctx, run)
+
+	// The request may be gone by now; the outcome is still recorded
+	finishCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.imports.AddRowErrors(finishCtx, run.rejected); err != nil {
+		run.abort(err)
+	}
+	if err := s.imports.FinishImport(finishCtx, run.imp); err != nil {
+		s.logger.Error("Failed to record outcome of import %s: %v", run.imp.ID.Hex(), err)
+	}
+
+	s.logger.Info("Import %s: %d rows, %d accepted, %d suppressed, %d replayed, %d rejected",
+		run.imp.ID.Hex(), run.imp.Rows, run.imp.Accepted, run.imp.Suppressed, run.imp.Replayed, run.imp.Rejected)
+	return run.imp, nil
+}
+
+// createChunk creates the buffered messages and rejects those that fail. When
+// the request was cancelled meanwhile, the rows that were not created are not
+// at fault and the import is aborted instead.
+func (s *importService) createChunk(ctx context.Context, run *importRun) {
+	if len(run.messages) == 0 {
+		return
+	}
+
+	results := s.messages.CreateMessages(ctx, run.messages)
+	cancelled := ctx.Err()
+	for i, result := range results {
+		switch {
+		case result.Err != nil && cancelled != nil:
+		case result.Err != nil:
+			run.reject(run.rows[i].Line, run.rows[i].Values, result.Err)
+		case result.Replayed:
+			run.imp.Replayed++
+		case run.messages[i].Status == domain.StatusSuppressed:
+			run.imp.Suppressed++
+		default:
+			run.imp.Accepted++
+		}
+	}
+	if cancelled != nil {
+		run.abort(cancelled)
+	}
+
+	run.messages = run.messages[:0]
+	run.rows = run.rows[:0]
+}
+
+func (run *importRun) reject(line int, values []string, err error) {
+	run.imp.Rejected++
+	run.rejected = append(run.rejected, domain.ImportRowError{
+		ImportID: run.imp.ID,
+		Line:     line,
+		Values:   values,
+		Error:    err.Error(),
+	})
+}
+
+func (run *importRun) abort(err error) {
+	run.imp.Status = domain.ImportAborted
+	run.imp.Error = err.Error()
+}
+
+func (s *importService) GetImport(ctx context.Context, id primitive.ObjectID) (*domain.Import, error) {
+	return s.imports.GetImport(ctx, id)
+}
+
+func (s *importService) EachRowError(ctx context.Context, id primitive.ObjectID, fn func(domain.ImportRowError) error) error {
+	if _, err := s.imports.GetImport(ctx, id); err != nil {
+		return err
+	}
+	return s.imports.EachRowError(ctx, id, fn)
+}
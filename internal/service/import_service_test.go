@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryImports struct {
+	repository.ImportRepository
+
+	imp      *domain.Import
+	finished bool
+	rows     []domain.ImportRowError
+}
+
+func (r *memoryImports) CreateImport(ctx context.Context, imp *domain.Import) error {
+	imp.ID = primitive.NewObjectID()
+	r.imp = imp
+	return nil
+}
+
+func (r *memoryImports) FinishImport(ctx context.Context, imp *domain.Import) error {
+	r.finished = true
+	return nil
+}
+
+func (r *memoryImports) AddRowErrors(ctx context.Context, rows []domain.ImportRowError) error {
+	r.rows = append(r.rows, rows...)
+	return nil
+}
+
+func TestImport_CountsAndReportsRejectedRows(t *testing.T) {
+	repo := &insertRepository{reject: map[string]bool{"Hi taken": true}}
+	messages := NewMessageService(repo, nil, nil, logger.New(), Options{})
+	imports := &memoryImports{}
+	svc := NewImportService(messages, imports, logger.New(), 2)
+
+	csv := "phone,name\n" +
+		"+905551111111,Ada\n" +
+		",Grace\n" +
+		"+905552222222,\"bad\"quote\n" +
+		"+905553333333,taken\n" +
+		"+905554444444,Linus\n"
+
+	imp, err := svc.Import(context.Background(), strings.NewReader(csv), "spring.csv",
+		domain.ImportMapping{PhoneColumn: "phone", Template: "Hi {{name}}"})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if imp.Status != domain.ImportCompleted || imp.Rows != 5 || imp.Accepted != 2 || imp.Rejected != 3 {
+		t.Errorf("import = %+v, want 5 rows, 2 accepted, 3 rejected", imp)
+	}
+	if !imports.finished {
+		t.Error("import outcome was not recorded")
+	}
+
+	var lines []int
+	for _, row := range imports.rows {
+		lines = append(lines, row.Line)
+		if row.ImportID != imp.ID || row.Error == "" {
+			t.Errorf("row error = %+v", row)
+		}
+	}
+	if len(lines) != 3 || lines[0] != 3 || lines[1] != 4 || lines[2] != 5 {
+		t.Errorf("rejected lines = %v, want [3 4 5]", lines)
+	}
+}
+
+func TestImport_RejectsUnusableHeader(t *testing.T) {
+	svc := NewImportService(nil, &memoryImports{}, logger.New(), 10)
+
+	_, err := svc.Import(context.Background(), strings.NewReader("name\nAda\n"), "", domain.ImportMapping{})
+	if !errors.Is(err, domain.ErrMissingColumn) {
+		t.Errorf("err = %v, want ErrMissingColumn", err)
+	}
+
+	_, err = svc.Import(context.Background(), strings.NewReader(""), "", domain.ImportMapping{})
+	if !errors.Is(err, domain.ErrInvalidCSV) {
+		t.Errorf("err = %v, want ErrInvalidCSV", err)
+	}
+}
+
+// cancellingMessages cancels the import request while creating a chunk
+type cancellingMessages struct {
+	MessageService
+
+	cancel context.CancelFunc
+}
+
+func (m *cancellingMessages) CreateMessages(ctx context.Context, messages []*domain.Message) []CreateResult {
+	m.cancel()
+	results := make([]CreateResult, len(messages))
+	for i := range results {
+		results[i].Err = ctx.Err()
+	}
+	return results
+}
+
+func TestImport_CancelledRequestAborts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	imports := &memoryImports{}
+	svc := NewImportService(&cancellingMessages{cancel: cancel}, imports, logger.New(), 2)
+
+	csv := "phone_number,content\n+905551111111,a\n+905552222222,b\n+905553333333,c\n"
+	imp, err := svc.Import(ctx, strings.NewReader(csv), "", domain.ImportMapping{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if imp.Status != domain.ImportAborted || imp.Rejected != 0 || len(imports.rows) != 0 {
+		t.Errorf("import = %+v with %d row errors, want aborted without rejecting rows", imp, len(imports.rows))
+	}
+}
+
+func TestImport_CountsSuppressedRowsSeparately(t *testing.T) {
+	messages := NewMessageService(&insertRepository{}, nil, nil, logger.New(), dedupOptions(newMemoryDuplicates()))
+	svc := NewImportService(messages, &memoryImports{}, logger.New(), 10)
+
+	csv := "phone_number,content\n+905551111111,hi\n+905551111111,hi\n+905552222222,hi\n"
+	imp, err := svc.Import(context.Background(), strings.NewReader(csv), "", domain.ImportMapping{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if imp.Accepted != 2 || imp.Suppressed != 1 || imp.Rejected != 0 {
+		t.Errorf("import = %+v, want 2 accepted and 1 suppressed", imp)
+	}
+}
@@ -2,25 +2,89 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
 	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/redis"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type MessageService interface {
-	ProcessPendingMessages(ctx context.Context, batchSize int) error
-	GetSentMessages(ctx context.Context) ([]*domain.Message, error)
-	CreateMessage(ctx context.Context, phoneNumber, content string) error
+	ProcessPendingMessages(ctx context.Context, batchSize int) (*BatchResult, error)
+	GetSentMessages(ctx context.Context, query repository.MessageQuery) (*repository.MessagePage, error)
+	ListMessages(ctx context.Context, query repository.MessageQuery) (*repository.MessagePage, error)
+	GetMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error)
+	ExportMessages(ctx context.Context, query repository.MessageQuery, fn func(*domain.Message) error) error
+	CreateMessage(ctx context.Context, message *domain.Message) (bool, error)
+	CreateMessages(ctx context.Context, messages []*domain.Message) []CreateResult
+	GetMessageStats(ctx context.Context) (map[domain.MessageStatus]int64, error)
+	GetDeadLetterMessages(ctx context.Context, limit int) ([]*domain.Message, error)
+	GetDeadLetterMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error)
+	RequeueDeadLetter(ctx context.Context, id primitive.ObjectID) error
+	CancelMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error)
+	RetryMessage(ctx context.Context, id primitive.ObjectID, phoneNumber string) (*domain.Message, error)
+	RecordDeliveryReceipt(ctx context.Context, receipt domain.DeliveryReceipt) (*domain.Message, error)
+	GetMessageEvents(ctx context.Context, id primitive.ObjectID) ([]domain.StatusEvent, error)
 }
 
+// BatchResult summarizes a single ProcessPendingMessages call
+type BatchResult struct {
+	Claimed int `json:"claimed"`
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Expired int `json:"expired"`
+	// Suppressed counts duplicates of recent messages that were not sent
+	Suppressed int `json:"suppressed"`
+	// DeadLettered counts failed messages that exhausted their attempts
+	DeadLettered int `json:"dead_lettered"`
+	// Deferred counts claimed messages returned to pending without an attempt
+	Deferred int `json:"deferred"`
+}
+
+// Options tunes how messages are claimed and retried
+type Options struct {
+	InstanceID    string
+	ClaimLease    time.Duration
+	StarvationAge time.Duration
+
+	// MaxAttempts is the number of sends before a message is dead-lettered
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// Concurrency is the number of recipients sent to in parallel within a batch
+	Concurrency int
+
+	// InsertChunkSize is the number of messages stored per InsertMany
+	InsertChunkSize int
+	// IdempotencyWindow is how long a repeated idempotency key returns the
+	// message first created with it
+	IdempotencyWindow time.Duration
+
+	// DedupWindow is how long a message suppresses others with the same
+	// recipient and content; zero disables the check
+	DedupWindow time.Duration
+	// Duplicates keeps the claims behind DedupWindow; the Redis client is
+	// used when it is nil
+	Duplicates DuplicateStore
+}
+
+const (
+	defaultInsertChunkSize   = 1000
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
 type messageService struct {
 	repo          repository.MessageRepository
 	webhookClient WebhookClient
 	redisClient   *redis.Client
+	duplicates    DuplicateStore
 	logger        *logger.Logger
+	opts          Options
 }
 
 func NewMessageService(
@@ -28,57 +92,190 @@ func NewMessageService(
 	webhookClient WebhookClient,
 	redisClient *redis.Client,
 	logger *logger.Logger,
+	opts Options,
 ) MessageService {
+	duplicates := opts.Duplicates
+	if duplicates == nil && redisClient != nil {
+		duplicates = redisClient
+	}
+
 	return &messageService{
 		repo:          repo,
 		webhookClient: webhookClient,
 		redisClient:   redisClient,
+		duplicates:    duplicates,
 		logger:        logger,
+		opts:          opts,
 	}
 }
 
-func (s *messageService) ProcessPendingMessages(ctx context.Context, batchSize int) error {
-	messages, err := s.repo.GetPendingMessages(ctx, batchSize)
+func (s *messageService) ProcessPendingMessages(ctx context.Context, batchSize int) (*BatchResult, error) {
+	result := &BatchResult{}
+
+	messages, err := s.repo.ClaimPendingMessages(ctx, repository.ClaimOptions{
+		Owner:         s.opts.InstanceID,
+		Limit:         batchSize,
+		Lease:         s.opts.ClaimLease,
+		StarvationAge: s.opts.StarvationAge,
+	})
+	result.Claimed = len(messages)
+	if err != nil && len(messages) == 0 {
+		return result, fmt.Errorf("failed to claim pending messages: %w", err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get pending messages: %w", err)
+		s.logger.Error("Claimed %d messages before error: %v", len(messages), err)
 	}
 
 	if len(messages) == 0 {
 		s.logger.Info("No pending messages to process")
-		return nil
+		return result, nil
 	}
 
-	s.logger.Info("Processing %d pending messages", len(messages))
+	s.logger.Info("Processing %d claimed messages", len(messages))
 
-	for _, msg := range messages {
-		if err := s.sendMessage(ctx, msg); err != nil {
-			s.logger.Error("Failed to send message ID %d: %v", msg.ID, err)
-			msg.MarkAsFailed()
-			if updateErr := s.repo.UpdateMessageStatus(ctx, msg); updateErr != nil {
-				s.logger.Error("Failed to update message status: %v", updateErr)
-			}
-			continue
+	if err := s.dispatch(ctx, messages, result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// handleSendFailure schedules another attempt with exponential backoff, or
+// dead-letters the message once it used up its attempts or failed permanently.
+// It reports whether the message was dead-lettered.
+func (s *messageService) handleSendFailure(msg *domain.Message, sendErr error) bool {
+	from := msg.Status
+	msg.RecordFailure(sendErr)
+
+	deadLettered := msg.Attempts >= s.opts.MaxAttempts || !IsRetryable(sendErr)
+	var err error
+	if deadLettered {
+		s.logger.Error("Message ID %s dead-lettered after %d attempts: %v", msg.ID.Hex(), msg.Attempts, sendErr)
+		err = msg.MarkAsDeadLetter()
+	} else {
+		delay := s.retryDelay(msg.Attempts)
+		// Waiting out a long Retry-After is the job of the next tick
+		if hint := retryAfter(sendErr); hint > delay {
+			delay = hint
 		}
+		err = msg.ScheduleRetry(time.Now().Add(delay))
+	}
+	if err != nil {
+		// e.g. the send went through but recording it failed; never resend a sent message
+		s.logger.Error("Message ID %s: %v", msg.ID.Hex(), err)
+		return false
+	}
+
+	// The batch context may already be cancelled; the outcome must still be recorded
+	updateCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		s.logger.Info("Successfully sent message ID %d to %s", msg.ID, msg.PhoneNumber)
+	event := msg.Event(from, s.actor())
+	event.ProviderResponse = providerResponse(sendErr)
+	event.Error = sendErr.Error()
+	if err := s.repo.UpdateMessageStatus(updateCtx, msg, event); err != nil {
+		s.logger.Error("Failed to update message status: %v", err)
+	}
+	if deadLettered {
+		s.releaseDedupClaim(updateCtx, msg)
 	}
 
-	return nil
+	return deadLettered
+}
+
+// actor identifies this scheduler instance in status events
+func (s *messageService) actor() string {
+	return domain.SchedulerActor(s.opts.InstanceID)
+}
+
+// maxResponseInEvent bounds the provider response body kept in a status event
+const maxResponseInEvent = 512
+
+// providerResponse summarises what the provider answered to a failed send
+func providerResponse(err error) string {
+	deliveryErr, ok := asDeliveryError(err)
+	if !ok || deliveryErr.StatusCode == 0 {
+		return ""
+	}
+
+	body := deliveryErr.Body
+	if body == "" {
+		return fmt.Sprintf("HTTP %d", deliveryErr.StatusCode)
+	}
+	if len(body) > maxResponseInEvent {
+		body = body[:maxResponseInEvent] + "..."
+	}
+	return fmt.Sprintf("HTTP %d: %s", deliveryErr.StatusCode, body)
+}
+
+// retryDelay doubles the base delay for every previous attempt, up to the maximum
+func (s *messageService) retryDelay(attempts int) time.Duration {
+	delay := s.opts.RetryBaseDelay
+	for i := 1; i < attempts && delay < s.opts.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.opts.RetryMaxDelay {
+		delay = s.opts.RetryMaxDelay
+	}
+	return delay
+}
+
+// expireMessage records that a message missed its window; it is never sent
+func (s *messageService) expireMessage(ctx context.Context, msg *domain.Message) {
+	s.logger.Info("Message ID %s expired at %v, skipping", msg.ID.Hex(), msg.ExpiresAt)
+	from := msg.Status
+	if err := msg.MarkAsExpired(); err != nil {
+		s.logger.Error("Message ID %s: %v", msg.ID.Hex(), err)
+		return
+	}
+
+	if err := s.repo.UpdateMessageStatus(ctx, msg, msg.Event(from, s.actor())); err != nil {
+		s.logger.Error("Failed to mark message as expired: %v", err)
+	}
+	s.releaseDedupClaim(ctx, msg)
+}
+
+// releaseClaims hands unprocessed messages back to the pending pool so another
+// replica can pick them up without waiting for the lease to expire
+func (s *messageService) releaseClaims(messages []*domain.Message) {
+	ids := make([]primitive.ObjectID, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.repo.ReleaseClaims(ctx, s.opts.InstanceID, ids); err != nil {
+		s.logger.Error("Failed to release %d claimed messages: %v", len(ids), err)
+	}
 }
 
 func (s *messageService) sendMessage(ctx context.Context, msg *domain.Message) error {
 	if err := msg.Validate(); err != nil {
-		return fmt.Errorf("message validation failed: %w", err)
+		return &DeliveryError{Kind: ErrorKindValidation, Err: fmt.Errorf("message validation failed: %w", err)}
 	}
 
-	resp, err := s.webhookClient.SendMessage(ctx, msg.PhoneNumber, msg.Content)
+	var resp *domain.WebhookResponse
+	var err error
+	if router, ok := s.webhookClient.(MessageRouter); ok {
+		resp, err = router.Route(ctx, msg)
+	} else {
+		resp, err = s.webhookClient.SendMessage(ctx, msg.PhoneNumber, msg.Content)
+	}
 	if err != nil {
 		return fmt.Errorf("webhook request failed: %w", err)
 	}
 
-	msg.MarkAsSent(resp.MessageID)
+	from := msg.Status
+	msg.Provider = resp.Provider
+	if err := msg.MarkAsSent(resp.MessageID); err != nil {
+		return err
+	}
 
-	if err := s.repo.UpdateMessageStatus(ctx, msg); err != nil {
+	event := msg.Event(from, s.actor())
+	event.ProviderResponse = fmt.Sprintf("%s (messageId %s)", resp.Message, resp.MessageID)
+	if err := s.storeSent(msg, event); err != nil {
 		return fmt.Errorf("failed to update message status: %w", err)
 	}
 
@@ -93,28 +290,304 @@ func (s *messageService) sendMessage(ctx context.Context, msg *domain.Message) e
 	return nil
 }
 
-func (s *messageService) GetSentMessages(ctx context.Context) ([]*domain.Message, error) {
-	messages, err := s.repo.GetSentMessages(ctx)
+// sentWriteBackoff is the first wait between attempts to store a sent status
+const sentWriteBackoff = 100 * time.Millisecond
+
+// storeSent records that msg went out. A message left processing is claimed
+// again once its lease expires and sent a second time, so the write is retried
+// until then; it only gives up early when another replica took the message.
+// The message is out, so this goes on even if the batch was cancelled.
+func (s *messageService) storeSent(msg *domain.Message, event domain.StatusEvent) error {
+	deadline := time.Now().Add(time.Minute)
+	if msg.LeaseExpiresAt != nil {
+		deadline = *msg.LeaseExpiresAt
+	}
+
+	backoff := sentWriteBackoff
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.repo.UpdateMessageStatus(ctx, msg, event)
+		cancel()
+
+		if err == nil || errors.Is(err, repository.ErrStatusMismatch) || errors.Is(err, repository.ErrMessageNotFound) {
+			return err
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		s.logger.Error("Failed to store sent status of message ID %s, retrying in %v: %v", msg.ID.Hex(), backoff, err)
+		time.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// GetSentMessages lists messages that left the dispatcher, most recently sent
+// first unless query says otherwise
+func (s *messageService) GetSentMessages(ctx context.Context, query repository.MessageQuery) (*repository.MessagePage, error) {
+	if len(query.Statuses) == 0 {
+		query.Statuses = domain.SentStatuses
+	}
+	if query.SortBy == "" {
+		query.SortBy = repository.SortBySentAt
+	}
+
+	page, err := s.repo.ListMessages(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get sent messages: %w", err)
 	}
-	return messages, nil
+	return page, nil
 }
 
-func (s *messageService) CreateMessage(ctx context.Context, phoneNumber, content string) error {
-	message := &domain.Message{
-		PhoneNumber: phoneNumber,
-		Content:     content,
-		Status:      domain.StatusPending,
+func (s *messageService) ListMessages(ctx context.Context, query repository.MessageQuery) (*repository.MessagePage, error) {
+	page, err := s.repo.ListMessages(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
 	}
+	return page, nil
+}
+
+// ExportMessages streams every message matching query to fn
+func (s *messageService) ExportMessages(ctx context.Context, query repository.MessageQuery, fn func(*domain.Message) error) error {
+	return s.repo.ExportMessages(ctx, query, fn)
+}
+
+func (s *messageService) GetMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error) {
+	return s.repo.GetMessageByID(ctx, id)
+}
+
+// CreateMessage creates a message. When its idempotency key was used within
+// Options.IdempotencyWindow it returns true instead and message is replaced
+// by the one created then. The unique index on external_id is what makes
+// this safe; Redis only remembers which message holds a key so that most
+// replays skip the insert that would fail, and every hit is confirmed
+// against the database.
+func (s *messageService) CreateMessage(ctx context.Context, message *domain.Message) (bool, error) {
+	result := s.CreateMessages(ctx, []*domain.Message{message})[0]
+	return result.Replayed, result.Err
+}
+
+// CreateResult is the outcome of creating one message
+type CreateResult struct {
+	// Replayed means the idempotency key of the message was used within the
+	// window and the message now holds the original instead
+	Replayed bool
+	Err      error
+}
+
+// CreateMessages validates each message and stores the valid ones in chunks
+// of Options.InsertChunkSize. The result holds the outcome of each message in
+// order.
+func (s *messageService) CreateMessages(ctx context.Context, messages []*domain.Message) []CreateResult {
+	results := make([]CreateResult, len(messages))
+
+	now := time.Now()
+	var valid []*domain.Message
+	var validIndexes []int
+	for i, message := range messages {
+		if results[i].Err = prepareMessage(message, now); results[i].Err == nil {
+			valid = append(valid, message)
+			validIndexes = append(validIndexes, i)
+		}
+	}
+
+	// Keys Redis remembers are replayed without a failed insert
+	var pending []int
+	originals := s.cachedOriginals(ctx, valid, now)
+	for i, index := range validIndexes {
+		if originals[i] != nil {
+			results[index] = replay(messages[index], originals[i])
+			continue
+		}
+		pending = append(pending, index)
+	}
+
+	// Duplicates are stored as suppressed, so the check comes before the insert
+	toInsert := make([]*domain.Message, len(pending))
+	for i, index := range pending {
+		toInsert[i] = messages[index]
+	}
+	claims := s.suppressDuplicates(ctx, toInsert)
+
+	chunkSize := s.opts.InsertChunkSize
+	if chunkSize < 1 {
+		chunkSize = defaultInsertChunkSize
+	}
+
+	for start := 0; start < len(pending); start += chunkSize {
+		end := start + chunkSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		indexes := pending[start:end]
+
+		chunk := make([]*domain.Message, len(indexes))
+		for i, index := range indexes {
+			chunk[i] = messages[index]
+		}
+
+		err := s.repo.CreateMessages(ctx, chunk)
+
+		var insertErr *repository.InsertError
+		switch {
+		case errors.As(err, &insertErr):
+			for i, itemErr := range insertErr.Failed {
+				if errors.Is(itemErr, repository.ErrDuplicateExternalID) {
+					results[indexes[i]] = s.resolveDuplicate(ctx, messages[indexes[i]], now)
+					continue
+				}
+				results[indexes[i]].Err = fmt.Errorf("failed to create message: %w", itemErr)
+			}
+		case err != nil:
+			for _, index := range indexes {
+				results[index].Err = fmt.Errorf("failed to create message: %w", err)
+			}
+		}
+	}
+
+	// A claim of a message that was not created would suppress its next copy
+	var unused []dedupClaim
+	for i, index := range pending {
+		if claims[i].key != "" && (results[index].Err != nil || results[index].Replayed) {
+			unused = append(unused, claims[i])
+		}
+	}
+	s.releaseDedupClaims(ctx, unused)
+
+	s.rememberKeys(ctx, messages, results)
+	return results
+}
+
+// prepareMessage validates a new message and makes it pending
+func prepareMessage(message *domain.Message, now time.Time) error {
+	message.Status = domain.StatusPending
+	message.ApplyDefaults()
 
 	if err := message.Validate(); err != nil {
 		return fmt.Errorf("message validation failed: %w", err)
 	}
 
-	if err := s.repo.CreateMessage(ctx, message); err != nil {
-		return fmt.Errorf("failed to create message: %w", err)
+	if err := message.ValidateSendAt(now); err != nil {
+		return fmt.Errorf("message validation failed: %w", err)
+	}
+
+	if err := message.ValidateExpiry(now); err != nil {
+		return fmt.Errorf("message validation failed: %w", err)
+	}
+
+	message.Events = []domain.StatusEvent{{
+		To:    domain.StatusPending,
+		At:    now,
+		Actor: domain.ActorAPI,
+	}}
+
+	return nil
+}
+
+func (s *messageService) GetMessageStats(ctx context.Context) (map[domain.MessageStatus]int64, error) {
+	counts, err := s.repo.CountByStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message stats: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *messageService) GetDeadLetterMessages(ctx context.Context, limit int) ([]*domain.Message, error) {
+	messages, err := s.repo.GetMessagesByStatus(ctx, domain.StatusDeadLetter, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead-lettered messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *messageService) GetDeadLetterMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error) {
+	message, err := s.repo.GetMessageByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.Status != domain.StatusDeadLetter {
+		return nil, repository.ErrStatusMismatch
 	}
 
+	return message, nil
+}
+
+// RequeueDeadLetter gives a dead-lettered message a fresh set of attempts
+func (s *messageService) RequeueDeadLetter(ctx context.Context, id primitive.ObjectID) error {
+	if err := s.repo.RequeueMessage(ctx, id, domain.ActorAPI, domain.StatusDeadLetter); err != nil {
+		return err
+	}
+
+	s.logger.Info("Requeued dead-lettered message ID %s", id.Hex())
 	return nil
 }
+
+// CancelMessage stops a pending message from being sent. It returns
+// repository.ErrStatusMismatch once the scheduler has claimed the message.
+func (s *messageService) CancelMessage(ctx context.Context, id primitive.ObjectID) (*domain.Message, error) {
+	message, err := s.repo.CancelMessage(ctx, id, domain.ActorAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	s.releaseDedupClaim(ctx, message)
+
+	s.logger.Info("Cancelled message ID %s", id.Hex())
+	return message, nil
+}
+
+// RetryMessage sends a failed or dead-lettered message again with fresh
+// attempts, to phoneNumber instead of the original recipient when given
+func (s *messageService) RetryMessage(ctx context.Context, id primitive.ObjectID, phoneNumber string) (*domain.Message, error) {
+	err := s.repo.RetryMessage(ctx, id, domain.ActorAPI, phoneNumber, domain.StatusFailed, domain.StatusDeadLetter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Retrying message ID %s", id.Hex())
+	return s.repo.GetMessageByID(ctx, id)
+}
+
+// RecordDeliveryReceipt applies a provider delivery receipt. Duplicate,
+// out-of-order and illegal receipts return domain.ErrDuplicateReceipt,
+// domain.ErrStaleReceipt or a *domain.TransitionError and leave the message
+// unchanged.
+func (s *messageService) RecordDeliveryReceipt(ctx context.Context, receipt domain.DeliveryReceipt) (*domain.Message, error) {
+	if err := receipt.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Two receipts for the same message may race; the loser re-reads and re-checks
+	for attempt := 0; ; attempt++ {
+		msg, err := s.repo.GetMessageByProviderID(ctx, receipt.Provider, receipt.MessageID)
+		if err != nil {
+			return nil, err
+		}
+
+		from := msg.Status
+		if err := msg.ApplyReceipt(receipt); err != nil {
+			return msg, err
+		}
+
+		event := msg.Event(from, domain.ActorCallback)
+		event.ProviderResponse = string(receipt.Status)
+		event.Error = receipt.ErrorCode
+		err = s.repo.UpdateMessageStatus(ctx, msg, event)
+		if errors.Is(err, repository.ErrStatusMismatch) && attempt < 2 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.logger.Info("Message ID %s is %s", msg.ID.Hex(), msg.Status)
+		return msg, nil
+	}
+}
+
+func (s *messageService) GetMessageEvents(ctx context.Context, id primitive.ObjectID) ([]domain.StatusEvent, error) {
+	return s.repo.GetMessageEvents(ctx, id)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/repository"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertRepository records CreateMessages chunks and fails the messages
+// whose content is in reject
+type insertRepository struct {
+	repository.MessageRepository
+
+	chunks [][]*domain.Message
+	reject map[string]bool
+}
+
+func (r *insertRepository) CreateMessages(ctx context.Context, messages []*domain.Message) error {
+	r.chunks = append(r.chunks, messages)
+
+	failed := map[int]error{}
+	for i, message := range messages {
+		if r.reject[message.Content] {
+			failed[i] = errors.New("duplicate key")
+		}
+	}
+	if len(failed) > 0 {
+		return &repository.InsertError{Failed: failed}
+	}
+	return nil
+}
+
+func TestCreateMessages_ChunksAndReportsPerItem(t *testing.T) {
+	repo := &insertRepository{reject: map[string]bool{"taken": true}}
+	svc := NewMessageService(repo, nil, nil, logger.New(), Options{InsertChunkSize: 2})
+
+	messages := []*domain.Message{
+		{PhoneNumber: "+905551111111", Content: "one"},
+		{PhoneNumber: "", Content: "no recipient"},
+		{PhoneNumber: "+905552222222", Content: "two"},
+		{PhoneNumber: "+905553333333", Content: "taken"},
+		{PhoneNumber: "+905554444444", Content: "three"},
+	}
+
+	results := svc.CreateMessages(context.Background(), messages)
+
+	if len(results) != len(messages) {
+		t.Fatalf("got %d results, want %d", len(results), len(messages))
+	}
+	for i, wantErr := range []bool{false, true, false, true, false} {
+		if (results[i].Err != nil) != wantErr {
+			t.Errorf("item %d: err = %v, want error %v", i, results[i].Err, wantErr)
+		}
+	}
+	if !errors.Is(results[1].Err, domain.ErrInvalidPhoneNumber) {
+		t.Errorf("item 1: err = %v, want ErrInvalidPhoneNumber", results[1].Err)
+	}
+
+	// The invalid message is never inserted; the four valid ones go in two chunks
+	if len(repo.chunks) != 2 || len(repo.chunks[0]) != 2 || len(repo.chunks[1]) != 2 {
+		t.Fatalf("chunks = %v, want two of two", repo.chunks)
+	}
+	if repo.chunks[1][0].Content != "taken" {
+		t.Errorf("second chunk starts with %q, want taken", repo.chunks[1][0].Content)
+	}
+	if messages[0].Status != domain.StatusPending || len(messages[0].Events) != 1 {
+		t.Errorf("created message = %+v, want pending with one event", messages[0])
+	}
+}
+
+// keyedRepository holds messages by external_id like the unique index does
+type keyedRepository struct {
+	repository.MessageRepository
+
+	byKey    map[string]*domain.Message
+	released []string
+}
+
+func (r *keyedRepository) CreateMessages(ctx context.Context, messages []*domain.Message) error {
+	failed := map[int]error{}
+	for i, message := range messages {
+		if err := r.CreateMessage(ctx, message); err != nil {
+			failed[i] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &repository.InsertError{Failed: failed}
+	}
+	return nil
+}
+
+func (r *keyedRepository) CreateMessage(ctx context.Context, message *domain.Message) error {
+	if _, taken := r.byKey[message.ExternalID]; taken {
+		return repository.ErrDuplicateExternalID
+	}
+	message.ID = primitive.NewObjectID()
+	r.byKey[message.ExternalID] = message
+	return nil
+}
+
+func (r *keyedRepository) GetMessageByExternalID(ctx context.Context, key string) (*domain.Message, error) {
+	if message, ok := r.byKey[key]; ok {
+		copied := *message
+		return &copied, nil
+	}
+	return nil, repository.ErrMessageNotFound
+}
+
+func (r *keyedRepository) ReleaseExternalID(ctx context.Context, key string, createdBefore time.Time) error {
+	if message, ok := r.byKey[key]; ok && message.CreatedAt.Before(createdBefore) {
+		delete(r.byKey, key)
+		r.released = append(r.released, key)
+	}
+	return nil
+}
+
+func TestCreateMessages_ReplaysIdempotencyKeys(t *testing.T) {
+	original := &domain.Message{
+		ID:          primitive.NewObjectID(),
+		PhoneNumber: "+905551111111",
+		Content:     "hello",
+		ExternalID:  "order-1",
+		Status:      domain.StatusSent,
+		CreatedAt:   time.Now().Add(-time.Hour),
+	}
+	stale := &domain.Message{
+		ID:          primitive.NewObjectID(),
+		PhoneNumber: "+905552222222",
+		Content:     "old",
+		ExternalID:  "order-2",
+		CreatedAt:   time.Now().Add(-48 * time.Hour),
+	}
+	repo := &keyedRepository{byKey: map[string]*domain.Message{"order-1": original, "order-2": stale}}
+	svc := NewMessageService(repo, nil, nil, logger.New(), Options{IdempotencyWindow: 24 * time.Hour})
+
+	messages := []*domain.Message{
+		{PhoneNumber: "+905551111111", Content: "hello", ExternalID: "order-1"},
+		{PhoneNumber: "+905551111111", Content: "changed", ExternalID: "order-1"},
+		{PhoneNumber: "+905552222222", Content: "new", ExternalID: "order-2"},
+	}
+
+	results := svc.CreateMessages(context.Background(), messages)
+
+	if !results[0].Replayed || results[0].Err != nil {
+		t.Errorf("item 0: result = %+v, want replayed", results[0])
+	}
+	if messages[0].ID != original.ID || messages[0].Status != domain.StatusSent {
+		t.Errorf("item 0: message = %+v, want the original", messages[0])
+	}
+	if !errors.Is(results[1].Err, domain.ErrIdempotencyConflict) {
+		t.Errorf("item 1: err = %v, want ErrIdempotencyConflict", results[1].Err)
+	}
+	// A key older than the window is released and used for the new message
+	if results[2].Replayed || results[2].Err != nil {
+		t.Errorf("item 2: result = %+v, want created", results[2])
+	}
+	if len(repo.released) != 1 || repo.byKey["order-2"] != messages[2] {
+		t.Errorf("order-2 = %+v, want held by the new message", repo.byKey["order-2"])
+	}
+}
+
+// receiptRepository holds sent messages by provider and provider message id
+type receiptRepository struct {
+	repository.MessageRepository
+
+	messages map[[2]string]*domain.Message
+}
+
+func (r *receiptRepository) GetMessageByProviderID(ctx context.Context, provider, messageID string) (*domain.Message, error) {
+	if message, ok := r.messages[[2]string{provider, messageID}]; ok {
+		return message, nil
+	}
+	return nil, repository.ErrMessageNotFound
+}
+
+func (r *receiptRepository) UpdateMessageStatus(ctx context.Context, message *domain.Message, event domain.StatusEvent) error {
+	return nil
+}
+
+func TestRecordDeliveryReceipt_ScopedByProvider(t *testing.T) {
+	primary := &domain.Message{ID: primitive.NewObjectID(), Status: domain.StatusSent, Provider: "primary"}
+	backup := &domain.Message{ID: primitive.NewObjectID(), Status: domain.StatusSent, Provider: "backup"}
+	repo := &receiptRepository{messages: map[[2]string]*domain.Message{
+		{"primary", "42"}: primary,
+		{"backup", "42"}:  backup,
+	}}
+	svc := NewMessageService(repo, nil, nil, logger.New(), Options{})
+
+	msg, err := svc.RecordDeliveryReceipt(context.Background(), domain.DeliveryReceipt{
+		Provider:  "backup",
+		MessageID: "42",
+		Status:    domain.StatusDelivered,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("RecordDeliveryReceipt: %v", err)
+	}
+	if msg != backup || backup.Status != domain.StatusDelivered || primary.Status != domain.StatusSent {
+		t.Errorf("primary = %s, backup = %s, want only the backup message delivered", primary.Status, backup.Status)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/redis"
+)
+
+// RateLimiter throttles calls to the provider
+type RateLimiter interface {
+	// Wait blocks until a send may go out or ctx is done
+	Wait(ctx context.Context) error
+}
+
+// TokenBucket is an in-process token bucket refilled at Rate tokens per
+// second and holding at most Burst tokens
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket. A burst below 1 is raised to 1.
+func NewTokenBucket(rate float64, burst int, clock Clock) *TokenBucket {
+	if clock == nil {
+		clock = realClock{}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		clock:  clock,
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := b.take()
+		if wait == 0 {
+			return nil
+		}
+
+		select {
+		case <-b.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// take consumes a token if one is available, otherwise it returns how long
+// until the next one is
+func (b *TokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// RedisTokenBucket shares one token bucket between all replicas through Redis.
+// While Redis is unreachable it falls back to a per-process bucket, so sending
+// continues at the configured rate on each replica instead of stopping.
+type RedisTokenBucket struct {
+	client   *redis.Client
+	key      string
+	rate     float64
+	burst    int
+	clock    Clock
+	fallback *TokenBucket
+	logger   *logger.Logger
+}
+
+func NewRedisTokenBucket(client *redis.Client, key string, rate float64, burst int, clock Clock, logger *logger.Logger) *RedisTokenBucket {
+	if clock == nil {
+		clock = realClock{}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RedisTokenBucket{
+		client:   client,
+		key:      key,
+		rate:     rate,
+		burst:    burst,
+		clock:    clock,
+		fallback: NewTokenBucket(rate, burst, clock),
+		logger:   logger,
+	}
+}
+
+func (b *RedisTokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait, err := b.client.TakeToken(ctx, b.key, b.rate, b.burst)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			b.logger.Error("Shared rate limiter unavailable, using local limit: %v", err)
+			return b.fallback.Wait(ctx)
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		select {
+		case <-b.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
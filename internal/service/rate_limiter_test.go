@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_BurstThenRate(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	bucket := NewTokenBucket(2, 3, clock)
+	start := clock.now
+
+	for i := 0; i < 7; i++ {
+		if err := bucket.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+
+	// Three tokens up front, then one every 500ms for the remaining four
+	if elapsed := clock.now.Sub(start); elapsed != 2*time.Second {
+		t.Errorf("elapsed = %v, want 2s", elapsed)
+	}
+	if len(clock.waits) != 4 {
+		t.Errorf("waits = %v, want four", clock.waits)
+	}
+}
+
+func TestTokenBucket_Refills(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	bucket := NewTokenBucket(1, 2, clock)
+
+	bucket.Wait(context.Background())
+	bucket.Wait(context.Background())
+	clock.now = clock.now.Add(10 * time.Second)
+
+	for i := 0; i < 2; i++ {
+		bucket.Wait(context.Background())
+	}
+	if len(clock.waits) != 0 {
+		t.Errorf("waits = %v, want none after refill capped at burst", clock.waits)
+	}
+
+	bucket.Wait(context.Background())
+	if len(clock.waits) != 1 {
+		t.Errorf("waits = %v, want one once the burst is spent", clock.waits)
+	}
+}
+
+func TestTokenBucket_Cancelled(t *testing.T) {
+	bucket := NewTokenBucket(0.001, 1, nil)
+	bucket.Wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := bucket.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
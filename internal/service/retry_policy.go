@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JitterMode spreads retries of concurrent senders so they do not retry in lockstep
+type JitterMode string
+
+const (
+	JitterNone         JitterMode = "none"
+	JitterFull         JitterMode = "full"
+	JitterDecorrelated JitterMode = "decorrelated"
+)
+
+func ParseJitterMode(value string) (JitterMode, error) {
+	mode := JitterMode(strings.ToLower(value))
+	switch mode {
+	case JitterNone, JitterFull, JitterDecorrelated:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown jitter mode %q", value)
+}
+
+// Clock abstracts time so retry timing can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RetryPolicy decides how long to wait between attempts to deliver one message
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Jitter     JitterMode
+	// Budget caps the total time spent on one message including waits; zero means no cap
+	Budget time.Duration
+
+	Clock  Clock
+	random func() float64
+}
+
+func (p *RetryPolicy) clock() Clock {
+	if p.Clock == nil {
+		return realClock{}
+	}
+	return p.Clock
+}
+
+func (p *RetryPolicy) rand() float64 {
+	if p.random == nil {
+		return rand.Float64()
+	}
+	return p.random()
+}
+
+// Delay returns the wait before retry number attempt (starting at 1), given
+// the previous delay and the provider's Retry-After hint, if any
+func (p *RetryPolicy) Delay(attempt int, previous, retryAfter time.Duration) time.Duration {
+	var delay time.Duration
+
+	switch p.Jitter {
+	case JitterDecorrelated:
+		// delay = random between base and three times the previous delay
+		if previous < p.BaseDelay {
+			previous = p.BaseDelay
+		}
+		upper := previous * 3
+		delay = p.BaseDelay + time.Duration(p.rand()*float64(upper-p.BaseDelay))
+	case JitterFull:
+		delay = time.Duration(p.rand() * float64(p.exponential(attempt)))
+	default:
+		delay = p.exponential(attempt)
+	}
+
+	// The provider knows best when it can take traffic again
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+
+	// A longer hint is left to the next tick; see RetryAfterTooLong
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// RetryAfterTooLong reports whether the provider asked to wait longer than
+// one retry may; the message is then retried on a later tick instead
+func (p *RetryPolicy) RetryAfterTooLong(retryAfter time.Duration) bool {
+	return p.MaxDelay > 0 && retryAfter > p.MaxDelay
+}
+
+// exponential doubles the base delay for every attempt, up to MaxDelay
+func (p *RetryPolicy) exponential(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+		delay *= 2
+	}
+	return delay
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock advances instantly and records every wait
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	half := func() float64 { return 0.5 }
+
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		attempt    int
+		previous   time.Duration
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{
+			name:    "exponential",
+			policy:  RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: JitterNone},
+			attempt: 3,
+			want:    4 * time.Second,
+		},
+		{
+			name:    "exponential capped",
+			policy:  RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: JitterNone},
+			attempt: 10,
+			want:    10 * time.Second,
+		},
+		{
+			name:    "full jitter",
+			policy:  RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: JitterFull, random: half},
+			attempt: 3,
+			want:    2 * time.Second,
+		},
+		{
+			name:     "decorrelated jitter",
+			policy:   RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: JitterDecorrelated, random: half},
+			attempt:  2,
+			previous: 3 * time.Second,
+			want:     5 * time.Second,
+		},
+		{
+			name:       "retry-after wins over shorter backoff",
+			policy:     RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: JitterNone},
+			attempt:    1,
+			retryAfter: 20 * time.Second,
+			want:       20 * time.Second,
+		},
+		{
+			name:       "retry-after capped at max delay",
+			policy:     RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: JitterNone},
+			attempt:    1,
+			retryAfter: time.Hour,
+			want:       time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Delay(tt.attempt, tt.previous, tt.retryAfter)
+			if got != tt.want {
+				t.Errorf("Delay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "30", want: 30 * time.Second},
+		{value: "-5", want: 0},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{value: "garbage", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookClient_HonorsRetryAfterAndBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := NewWebhookClient(server.URL, "key", time.Second, &RetryPolicy{
+		MaxRetries: 5,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Minute,
+		Jitter:     JitterNone,
+		Budget:     25 * time.Second,
+		Clock:      clock,
+	}, nil)
+
+	_, err := client.SendMessage(context.Background(), "+905551111111", "Test")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Two 10s waits fit the 25s budget; the third would exceed it
+	if len(clock.waits) != 2 {
+		t.Fatalf("waits = %v, want two", clock.waits)
+	}
+	for _, wait := range clock.waits {
+		if wait != 10*time.Second {
+			t.Errorf("wait = %v, want Retry-After of 10s", wait)
+		}
+	}
+
+	if !IsRetryable(err) {
+		t.Error("budget exhaustion should stay retryable across ticks")
+	}
+}
+
+func TestWebhookClient_LeavesLongRetryAfterToNextTick(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := NewWebhookClient(server.URL, "key", time.Second, &RetryPolicy{
+		MaxRetries: 3,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Minute,
+		Jitter:     JitterNone,
+		Clock:      clock,
+	}, nil)
+
+	_, err := client.SendMessage(context.Background(), "+905551111111", "Test")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(clock.waits) != 0 {
+		t.Errorf("waits = %v, want none for an hour-long Retry-After", clock.waits)
+	}
+	if !IsRetryable(err) || retryAfter(err) != time.Hour {
+		t.Errorf("err = %v, want retryable with the hint kept", err)
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+// MessageRouter is implemented by clients that choose where to send based on
+// the whole message rather than only the recipient and content
+type MessageRouter interface {
+	Route(ctx context.Context, msg *domain.Message) (*domain.WebhookResponse, error)
+}
+
+// Provider is one named SMS provider
+type Provider struct {
+	Name   string
+	Client WebhookClient
+	// Breaker is the circuit breaker wrapped around Client, nil when disabled
+	Breaker *CircuitBreaker
+}
+
+// ProviderStatus is a provider as reported by the status endpoints
+type ProviderStatus struct {
+	Name           string           `json:"name"`
+	CircuitBreaker *BreakerSnapshot `json:"circuit_breaker,omitempty"`
+}
+
+// RouteTarget is a provider a rule sends to, with its share of the traffic
+type RouteTarget struct {
+	Provider string
+	Weight   int
+}
+
+// RoutingRule sends matching messages to its targets. Empty conditions match
+// every message.
+type RoutingRule struct {
+	Prefixes   []string
+	Priorities []domain.MessagePriority
+	Targets    []RouteTarget
+}
+
+// Router sends each message through the provider picked by the first matching
+// rule and falls over to the rule's other providers when that one's circuit is
+// open or it keeps failing
+type Router struct {
+	providers []*Provider
+	byName    map[string]*Provider
+	rules     []RoutingRule
+	random    func() float64
+	logger    *logger.Logger
+}
+
+// NewRouter creates a router. Messages no rule matches go to the providers in
+// registration order.
+func NewRouter(providers []*Provider, rules []RoutingRule, logger *logger.Logger) (*Router, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("at least one provider is required")
+	}
+
+	byName := make(map[string]*Provider, len(providers))
+	for _, p := range providers {
+		if _, ok := byName[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate provider %q", p.Name)
+		}
+		byName[p.Name] = p
+	}
+
+	for _, rule := range rules {
+		for _, target := range rule.Targets {
+			if _, ok := byName[target.Provider]; !ok {
+				return nil, fmt.Errorf("routing rule refers to unknown provider %q", target.Provider)
+			}
+		}
+	}
+
+	return &Router{
+		providers: providers,
+		byName:    byName,
+		rules:     rules,
+		random:    rand.Float64,
+		logger:    logger,
+	}, nil
+}
+
+// SendMessage routes a message of normal priority
+func (r *Router) SendMessage(ctx context.Context, phoneNumber, content string) (*domain.WebhookResponse, error) {
+	return r.Route(ctx, &domain.Message{PhoneNumber: phoneNumber, Content: content, Priority: domain.PriorityNormal})
+}
+
+func (r *Router) Route(ctx context.Context, msg *domain.Message) (*domain.WebhookResponse, error) {
+	var lastErr error
+
+	for _, provider := range r.candidates(msg) {
+		resp, err := provider.Client.SendMessage(ctx, msg.PhoneNumber, msg.Content)
+		if err == nil {
+			resp.Provider = provider.Name
+			return resp, nil
+		}
+
+		// A rejected message or a cancelled batch would fail the same way
+		// anywhere. A fatal error such as a revoked key is returned as well, so
+		// that sending pauses and the operator is alerted instead of every
+		// message quietly going to the next provider.
+		if ctx.Err() != nil || !(errors.Is(err, ErrCircuitOpen) || IsRetryable(err)) {
+			return nil, fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+
+		if lastErr == nil || !errors.Is(err, ErrCircuitOpen) {
+			lastErr = fmt.Errorf("provider %s: %w", provider.Name, err)
+		}
+		r.logger.Error("Provider %s unavailable, trying next: %v", provider.Name, err)
+	}
+
+	return nil, lastErr
+}
+
+// Status reports every provider with its circuit breaker state
+func (r *Router) Status() []ProviderStatus {
+	statuses := make([]ProviderStatus, 0, len(r.providers))
+	for _, p := range r.providers {
+		status := ProviderStatus{Name: p.Name}
+		if p.Breaker != nil {
+			snapshot := p.Breaker.Snapshot()
+			status.CircuitBreaker = &snapshot
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// candidates returns the providers to try for msg, in order
+func (r *Router) candidates(msg *domain.Message) []*Provider {
+	for _, rule := range r.rules {
+		if !rule.matches(msg) {
+			continue
+		}
+
+		targets := rule.Targets
+		first := r.pick(targets)
+
+		providers := []*Provider{r.byName[targets[first].Provider]}
+		for i, target := range targets {
+			if i != first {
+				providers = append(providers, r.byName[target.Provider])
+			}
+		}
+		return providers
+	}
+
+	return r.providers
+}
+
+// pick chooses the first target by weight. Targets without a weight only take
+// failover traffic; when none has a weight the first listed one is primary.
+func (r *Router) pick(targets []RouteTarget) int {
+	total := 0
+	for _, target := range targets {
+		total += target.Weight
+	}
+	if total == 0 {
+		return 0
+	}
+
+	n := int(r.random() * float64(total))
+	for i, target := range targets {
+		if n < target.Weight {
+			return i
+		}
+		n -= target.Weight
+	}
+	return len(targets) - 1
+}
+
+func (rule RoutingRule) matches(msg *domain.Message) bool {
+	if len(rule.Prefixes) > 0 {
+		number := normalizeNumber(msg.PhoneNumber)
+		matched := false
+		for _, prefix := range rule.Prefixes {
+			if strings.HasPrefix(number, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(rule.Priorities) > 0 {
+		priority := msg.Priority
+		if priority == "" {
+			priority = domain.PriorityNormal
+		}
+
+		matched := false
+		for _, p := range rule.Priorities {
+			if p == priority {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeNumber drops formatting and the international prefix so "+90 555",
+// "0090555" and "90555" all compare equal
+func normalizeNumber(number string) string {
+	number = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(number)
+	number = strings.TrimPrefix(number, "+")
+	return strings.TrimPrefix(number, "00")
+}
+
+// countryCodes maps ISO 3166 country codes to their calling codes for
+// country= routing conditions
+var countryCodes = map[string]string{
+	"AT": "43", "AZ": "994", "BE": "32", "BG": "359", "CA": "1", "CH": "41",
+	"CY": "357", "CZ": "420", "DE": "49", "DK": "45", "ES": "34", "FI": "358",
+	"FR": "33", "GB": "44", "GE": "995", "GR": "30", "HU": "36", "IE": "353",
+	"IT": "39", "NL": "31", "NO": "47", "PL": "48", "PT": "351", "RO": "40",
+	"RU": "7", "SA": "966", "SE": "46", "TR": "90", "AE": "971", "UA": "380",
+	"US": "1",
+}
+
+// ParseRoutingRules parses rules separated by ";", each of the form
+// "<conditions> -> <providers>". Conditions are "*" or "&"-joined
+// prefix=, country= and priority= lists; providers are a comma-separated
+// list of name[:weight]. For example:
+//
+//	prefix=+90 -> turkcell,backup; priority=critical -> premium; * -> primary:80,backup:20
+func ParseRoutingRules(spec string) ([]RoutingRule, error) {
+	var rules []RoutingRule
+
+	for _, raw := range strings.Split(spec, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.SplitN(raw, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("routing rule %q must have the form <conditions> -> <providers>", raw)
+		}
+
+		rule, err := parseConditions(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		if rule.Targets, err = parseTargets(strings.TrimSpace(parts[1])); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseConditions(spec string) (RoutingRule, error) {
+	var rule RoutingRule
+	if spec == "*" {
+		return rule, nil
+	}
+
+	for _, cond := range strings.Split(spec, "&") {
+		kv := strings.SplitN(strings.TrimSpace(cond), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return rule, fmt.Errorf("invalid routing condition %q", cond)
+		}
+
+		for _, value := range strings.Split(kv[1], ",") {
+			value = strings.TrimSpace(value)
+
+			switch strings.ToLower(strings.TrimSpace(kv[0])) {
+			case "prefix":
+				rule.Prefixes = append(rule.Prefixes, normalizeNumber(value))
+			case "country":
+				code, ok := countryCodes[strings.ToUpper(value)]
+				if !ok {
+					return rule, fmt.Errorf("unknown country %q, use prefix= instead", value)
+				}
+				rule.Prefixes = append(rule.Prefixes, code)
+			case "priority":
+				priority := domain.MessagePriority(strings.ToLower(value))
+				if !priority.IsValid() {
+					return rule, fmt.Errorf("invalid priority %q in routing rule", value)
+				}
+				rule.Priorities = append(rule.Priorities, priority)
+			default:
+				return rule, fmt.Errorf("unknown routing condition %q", kv[0])
+			}
+		}
+	}
+
+	return rule, nil
+}
+
+func parseTargets(spec string) ([]RouteTarget, error) {
+	var targets []RouteTarget
+
+	for _, raw := range strings.Split(spec, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		target := RouteTarget{Provider: raw}
+		if i := strings.Index(raw, ":"); i >= 0 {
+			weight, err := strconv.Atoi(raw[i+1:])
+			if err != nil || weight < 0 {
+				return nil, fmt.Errorf("invalid weight in routing target %q", raw)
+			}
+			target = RouteTarget{Provider: raw[:i], Weight: weight}
+		}
+		targets = append(targets, target)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("routing rule %q has no providers", spec)
+	}
+	return targets, nil
+}
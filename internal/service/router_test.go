@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UmutcanKalkan/auto-message-dispatcher/internal/domain"
+	"github.com/UmutcanKalkan/auto-message-dispatcher/pkg/logger"
+)
+
+func TestParseRoutingRules(t *testing.T) {
+	rules, err := ParseRoutingRules("prefix=+90,0044 -> tr,backup; country=DE & priority=critical,high -> eu:3,backup:1; * -> primary")
+	if err != nil {
+		t.Fatalf("ParseRoutingRules: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("rules = %d, want 3", len(rules))
+	}
+
+	if got := rules[0].Prefixes; len(got) != 2 || got[0] != "90" || got[1] != "44" {
+		t.Errorf("prefixes = %v, want [90 44]", got)
+	}
+	if got := rules[1].Prefixes; len(got) != 1 || got[0] != "49" {
+		t.Errorf("country prefixes = %v, want [49]", got)
+	}
+	if got := rules[1].Priorities; len(got) != 2 || got[0] != domain.PriorityCritical {
+		t.Errorf("priorities = %v", got)
+	}
+	if got := rules[1].Targets; got[0] != (RouteTarget{Provider: "eu", Weight: 3}) {
+		t.Errorf("targets = %v", got)
+	}
+	if len(rules[2].Prefixes) != 0 || len(rules[2].Priorities) != 0 {
+		t.Errorf("catch-all rule has conditions: %+v", rules[2])
+	}
+
+	for _, spec := range []string{"prefix=+90", "colour=red -> a", "country=XX -> a", "priority=urgent -> a", "* -> a:x", "* -> "} {
+		if _, err := ParseRoutingRules(spec); err == nil {
+			t.Errorf("ParseRoutingRules(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestRouter_RoutesByRule(t *testing.T) {
+	tr := &scriptedClient{}
+	premium := &scriptedClient{}
+	fallback := &scriptedClient{}
+	rules, _ := ParseRoutingRules("priority=critical -> premium; prefix=+90 -> tr")
+	router, err := NewRouter([]*Provider{
+		{Name: "fallback", Client: fallback},
+		{Name: "tr", Client: tr},
+		{Name: "premium", Client: premium},
+	}, rules, logger.New())
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	tests := []struct {
+		msg  domain.Message
+		want string
+	}{
+		{domain.Message{PhoneNumber: "+905551111111", Priority: domain.PriorityCritical}, "premium"},
+		{domain.Message{PhoneNumber: "+90 555 111 1111"}, "tr"},
+		{domain.Message{PhoneNumber: "+4915511111111"}, "fallback"},
+	}
+
+	for _, tt := range tests {
+		resp, err := router.Route(context.Background(), &tt.msg)
+		if err != nil {
+			t.Fatalf("Route: %v", err)
+		}
+		if resp.Provider != tt.want {
+			t.Errorf("%s routed to %s, want %s", tt.msg.PhoneNumber, resp.Provider, tt.want)
+		}
+	}
+}
+
+func TestRouter_WeightedSplit(t *testing.T) {
+	rules, _ := ParseRoutingRules("* -> a:1,b:3")
+	router, _ := NewRouter([]*Provider{
+		{Name: "a", Client: &scriptedClient{}},
+		{Name: "b", Client: &scriptedClient{}},
+	}, rules, logger.New())
+
+	for _, tt := range []struct {
+		random float64
+		want   string
+	}{{0.1, "a"}, {0.3, "b"}, {0.99, "b"}} {
+		router.random = func() float64 { return tt.random }
+		resp, _ := router.SendMessage(context.Background(), "+905551111111", "hi")
+		if resp.Provider != tt.want {
+			t.Errorf("random %v routed to %s, want %s", tt.random, resp.Provider, tt.want)
+		}
+	}
+}
+
+func TestRouter_FailsOver(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	primary := &scriptedClient{err: &DeliveryError{Kind: ErrorKindServer, StatusCode: 503}}
+	breaker := NewCircuitBreaker(primary, CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute}, clock)
+	secondary := &scriptedClient{}
+
+	router, _ := NewRouter([]*Provider{
+		{Name: "primary", Client: breaker, Breaker: breaker},
+		{Name: "secondary", Client: secondary},
+	}, nil, logger.New())
+
+	for i := 0; i < 2; i++ {
+		resp, err := router.SendMessage(context.Background(), "+905551111111", "hi")
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		if resp.Provider != "secondary" {
+			t.Errorf("provider = %s, want secondary", resp.Provider)
+		}
+	}
+	if primary.calls != 1 {
+		t.Errorf("primary calls = %d, want 1 before its circuit opened", primary.calls)
+	}
+	if status := router.Status(); status[0].CircuitBreaker.State != BreakerOpen {
+		t.Errorf("primary state = %s, want open", status[0].CircuitBreaker.State)
+	}
+}
+
+func TestRouter_NoFailoverForRejectedMessage(t *testing.T) {
+	primary := &scriptedClient{err: &DeliveryError{Kind: ErrorKindValidation, StatusCode: 400}}
+	secondary := &scriptedClient{}
+	router, _ := NewRouter([]*Provider{
+		{Name: "primary", Client: primary},
+		{Name: "secondary", Client: secondary},
+	}, nil, logger.New())
+
+	if _, err := router.SendMessage(context.Background(), "+905551111111", "hi"); err == nil {
+		t.Fatal("expected error")
+	}
+	if secondary.calls != 0 {
+		t.Errorf("secondary calls = %d, want 0", secondary.calls)
+	}
+}
+
+func TestRouter_NoFailoverForFatalError(t *testing.T) {
+	primary := &scriptedClient{err: &DeliveryError{Kind: ErrorKindAuth, StatusCode: 401}}
+	secondary := &scriptedClient{}
+	router, _ := NewRouter([]*Provider{
+		{Name: "primary", Client: primary},
+		{Name: "secondary", Client: secondary},
+	}, nil, logger.New())
+
+	_, err := router.SendMessage(context.Background(), "+905551111111", "hi")
+	if !IsFatal(err) {
+		t.Fatalf("err = %v, want the primary's 401 as fatal", err)
+	}
+	if secondary.calls != 0 {
+		t.Errorf("secondary calls = %d, want 0 so the auth failure is not hidden", secondary.calls)
+	}
+}
+
+func TestRouter_AllCircuitsOpen(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	failing := &scriptedClient{err: &DeliveryError{Kind: ErrorKindTimeout}}
+	breaker := NewCircuitBreaker(failing, CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute}, clock)
+	router, _ := NewRouter([]*Provider{{Name: "only", Client: breaker, Breaker: breaker}}, nil, logger.New())
+
+	router.SendMessage(context.Background(), "+905551111111", "hi")
+	if _, err := router.SendMessage(context.Background(), "+905551111111", "hi"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestNewRouter_UnknownProvider(t *testing.T) {
+	rules, _ := ParseRoutingRules("* -> missing")
+	if _, err := NewRouter([]*Provider{{Name: "a", Client: &scriptedClient{}}}, rules, logger.New()); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
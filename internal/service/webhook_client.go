@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -17,19 +19,21 @@ type WebhookClient interface {
 }
 
 type webhookClient struct {
-	url        string
-	authKey    string
-	client     *http.Client
-	maxRetries int
-	retryDelay time.Duration
+	url     string
+	authKey string
+	client  *http.Client
+	policy  *RetryPolicy
+	limiter RateLimiter
 }
 
-func NewWebhookClient(url, authKey string, timeout time.Duration, maxRetries int, retryDelay time.Duration) WebhookClient {
+// NewWebhookClient creates the provider client. Every HTTP attempt, retries
+// included, waits on limiter first; a nil limiter disables throttling.
+func NewWebhookClient(url, authKey string, timeout time.Duration, policy *RetryPolicy, limiter RateLimiter) WebhookClient {
 	return &webhookClient{
-		url:        url,
-		authKey:    authKey,
-		maxRetries: maxRetries,
-		retryDelay: retryDelay,
+		url:     url,
+		authKey: authKey,
+		policy:  policy,
+		limiter: limiter,
 		client: &http.Client{
 			Timeout: timeout,
 		},
@@ -38,22 +42,44 @@ func NewWebhookClient(url, authKey string, timeout time.Duration, maxRetries int
 
 func (w *webhookClient) SendMessage(ctx context.Context, phoneNumber, content string) (*domain.WebhookResponse, error) {
 	var lastErr error
+	var delay time.Duration
 
-	for attempt := 0; attempt <= w.maxRetries; attempt++ {
+	clock := w.policy.clock()
+	start := clock.Now()
+
+	for attempt := 0; attempt <= w.policy.MaxRetries; attempt++ {
 		if attempt > 0 {
-			delay := w.retryDelay * time.Duration(attempt)
+			if hint := retryAfter(lastErr); w.policy.RetryAfterTooLong(hint) {
+				return nil, fmt.Errorf("provider asked to retry after %v, longer than %v: %w", hint, w.policy.MaxDelay, lastErr)
+			}
+			delay = w.policy.Delay(attempt, delay, retryAfter(lastErr))
+
+			if w.policy.Budget > 0 && clock.Now().Sub(start)+delay > w.policy.Budget {
+				return nil, fmt.Errorf("retry budget of %v exhausted after %d attempts: %w", w.policy.Budget, attempt, lastErr)
+			}
+
 			select {
-			case <-time.After(delay):
+			case <-clock.After(delay):
 			case <-ctx.Done():
 				return nil, ctx.Err()
 			}
 		}
 
+		if w.limiter != nil {
+			if err := w.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
 		resp, err := w.doSendMessage(ctx, phoneNumber, content)
 		if err == nil {
 			return resp, nil
 		}
 
+		if !IsRetryable(err) {
+			return nil, err
+		}
+
 		lastErr = err
 	}
 
@@ -81,7 +107,7 @@ func (w *webhookClient) doSendMessage(ctx context.Context, phoneNumber, content
 
 	resp, err := w.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, classifyTransportError(err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -92,17 +118,63 @@ func (w *webhookClient) doSendMessage(ctx context.Context, phoneNumber, content
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, &DeliveryError{
+			Kind:       ErrorKindNetwork,
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("failed to read response: %w", err),
+		}
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, &DeliveryError{
+			Kind:       classifyStatus(resp.StatusCode),
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), w.policy.clock().Now()),
+			Err:        fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+		}
 	}
 
 	var webhookResp domain.WebhookResponse
 	if err := json.Unmarshal(body, &webhookResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, &DeliveryError{
+			Kind:       ErrorKindBadResponse,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			Err:        fmt.Errorf("failed to unmarshal response: %w", err),
+		}
 	}
 
 	return &webhookResp, nil
 }
+
+// retryAfter returns the provider's Retry-After hint carried by err, if any
+func retryAfter(err error) time.Duration {
+	if deliveryErr, ok := asDeliveryError(err); ok {
+		return deliveryErr.RetryAfter
+	}
+	return 0
+}
+
+func classifyTransportError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &DeliveryError{Kind: ErrorKindTimeout, Err: err}
+	}
+	return &DeliveryError{Kind: ErrorKindNetwork, Err: err}
+}
+
+func classifyStatus(statusCode int) ErrorKind {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorKindAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorKindRateLimited
+	case statusCode >= 500:
+		return ErrorKindServer
+	case statusCode >= 400:
+		return ErrorKindValidation
+	default:
+		return ErrorKindBadResponse
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookClient_ErrorClassification(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCode    int
+		body          string
+		wantKind      ErrorKind
+		wantCalls     int32
+		wantRetryable bool
+		wantFatal     bool
+	}{
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, wantKind: ErrorKindAuth, wantCalls: 1, wantFatal: true},
+		{name: "bad request", statusCode: http.StatusBadRequest, wantKind: ErrorKindValidation, wantCalls: 1},
+		{name: "rate limited", statusCode: http.StatusTooManyRequests, wantKind: ErrorKindRateLimited, wantCalls: 3, wantRetryable: true},
+		{name: "server error", statusCode: http.StatusBadGateway, wantKind: ErrorKindServer, wantCalls: 3, wantRetryable: true},
+		{name: "bad body", statusCode: http.StatusAccepted, body: "not json", wantKind: ErrorKindBadResponse, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewWebhookClient(server.URL, "key", time.Second, &RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}, nil)
+			_, err := client.SendMessage(context.Background(), "+905551111111", "Test")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			deliveryErr, ok := asDeliveryError(err)
+			if !ok {
+				t.Fatalf("expected DeliveryError, got %T: %v", err, err)
+			}
+			if deliveryErr.Kind != tt.wantKind {
+				t.Errorf("kind = %s, want %s", deliveryErr.Kind, tt.wantKind)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("retryable = %v, want %v", IsRetryable(err), tt.wantRetryable)
+			}
+			if IsFatal(err) != tt.wantFatal {
+				t.Errorf("fatal = %v, want %v", IsFatal(err), tt.wantFatal)
+			}
+		})
+	}
+}
+
+func TestWebhookClient_NetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	client := NewWebhookClient(url, "key", time.Second, &RetryPolicy{BaseDelay: time.Millisecond}, nil)
+	_, err := client.SendMessage(context.Background(), "+905551111111", "Test")
+
+	deliveryErr, ok := asDeliveryError(err)
+	if !ok || deliveryErr.Kind != ErrorKindNetwork {
+		t.Errorf("expected network error, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// claimDedupScript gives the key to the caller unless someone else holds it,
+// restarting its TTL, and returns the holder afterwards
+var claimDedupScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return holder
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return ARGV[1]
+`)
+
+func dedupKey(key string) string {
+	return "dedup:" + key
+}
+
+// ClaimDedupKeys claims each key for the owner at the same index, in order,
+// and returns who holds each key afterwards. An owner holding its key already
+// keeps it for another ttl; a key held by another owner is left untouched.
+func (c *Client) ClaimDedupKeys(ctx context.Context, keys, owners []string, ttl time.Duration) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.Cmd, len(keys))
+	_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = claimDedupScript.Eval(ctx, pipe, []string{dedupKey(key)}, owners[i], ttl.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim dedup keys: %w", err)
+	}
+
+	holders := make([]string, len(keys))
+	for i, cmd := range cmds {
+		holders[i], _ = cmd.Val().(string)
+	}
+	return holders, nil
+}
+
+// ReleaseDedupKeys frees each key its owner at the same index still holds
+func (c *Client) ReleaseDedupKeys(ctx context.Context, keys, owners []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			releaseLockScript.Eval(ctx, pipe, []string{dedupKey(key)}, owners[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release dedup keys: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func idempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+// RememberIdempotencyKeys maps idempotency keys to the ids of the messages
+// created with them for ttl. Keys already remembered keep their message.
+func (c *Client) RememberIdempotencyKeys(ctx context.Context, messageIDs map[string]string, ttl time.Duration) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, messageID := range messageIDs {
+			pipe.SetNX(ctx, idempotencyKey(key), messageID, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cache idempotency keys: %w", err)
+	}
+
+	return nil
+}
+
+// LookupIdempotencyKeys returns the message id remembered for each key, or an
+// empty string for keys that are not cached
+func (c *Client) LookupIdempotencyKeys(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = idempotencyKey(key)
+	}
+
+	values, err := c.MGet(ctx, redisKeys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up idempotency keys: %w", err)
+	}
+
+	ids := make([]string, len(keys))
+	for i, value := range values {
+		if id, ok := value.(string); ok {
+			ids[i] = id
+		}
+	}
+	return ids, nil
+}
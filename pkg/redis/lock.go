@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// renewLockScript extends the TTL only if the lock is still held by the caller
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes the lock only if it is still held by the caller
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock takes the lock for owner if it is free, or renews it if owner
+// already holds it. It reports whether owner holds the lock afterwards.
+func (c *Client) AcquireLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := c.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewLockScript.Run(ctx, c.Client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock: %w", err)
+	}
+
+	return renewed == 1, nil
+}
+
+// ReleaseLock frees the lock if owner still holds it
+func (c *Client) ReleaseLock(ctx context.Context, key, owner string) error {
+	if err := releaseLockScript.Run(ctx, c.Client, []string{key}, owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// GetLockHolder returns the current owner of the lock and its remaining TTL.
+// An empty owner means the lock is free.
+func (c *Client) GetLockHolder(ctx context.Context, key string) (string, time.Duration, error) {
+	pipe := c.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", 0, fmt.Errorf("failed to get lock holder: %w", err)
+	}
+
+	owner, err := getCmd.Result()
+	if err == redis.Nil {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get lock holder: %w", err)
+	}
+
+	return owner, ttlCmd.Val(), nil
+}
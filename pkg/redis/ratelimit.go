@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokenScript refills the bucket stored at KEYS[1] from the Redis clock,
+// takes one token if available and returns 0, or otherwise the milliseconds
+// until the next token. ARGV: rate per second, burst.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local clock = redis.call("TIME")
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+// TakeToken takes one token from the shared bucket at key. It returns zero
+// when a token was taken, or how long to wait before trying again.
+func (c *Client) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	waitMs, err := takeTokenScript.Run(ctx, c.Client, []string{key},
+		strconv.FormatFloat(rate, 'f', -1, 64), burst).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return time.Duration(waitMs) * time.Millisecond, nil
+}
@@ -12,30 +12,40 @@ db.messages.insertMany([
         phone_number: "+905551111111",
         content: "Test mesajı 1 - Insider Project",
         status: "pending",
+        priority: "normal",
+        priority_rank: 1,
         created_at: new Date()
     },
     {
         phone_number: "+905552222222",
         content: "Test mesajı 2 - Welcome to Insider",
         status: "pending",
+        priority: "normal",
+        priority_rank: 1,
         created_at: new Date()
     },
     {
         phone_number: "+905553333333",
         content: "Test mesajı 3 - Siparişiniz hazır",
         status: "pending",
+        priority: "normal",
+        priority_rank: 1,
         created_at: new Date()
     },
     {
         phone_number: "+905554444444",
         content: "Test mesajı 4 - Alışveriş için teşekkürler",
         status: "pending",
+        priority: "normal",
+        priority_rank: 1,
         created_at: new Date()
     },
     {
         phone_number: "+905555555555",
         content: "Test mesajı 5 - Sizin için özel indirim",
         status: "pending",
+        priority: "normal",
+        priority_rank: 1,
         created_at: new Date()
     }
 ]);